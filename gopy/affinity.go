@@ -0,0 +1,94 @@
+package gopy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+)
+
+// ringReplicas is the number of points each worker has on the hash ring, spreading keys evenly between workers.
+const ringReplicas = 64
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// hashRing assigns keys to nodes by consistent hashing. Each node owns the arcs of the ring ending at its points, so
+// adding a node only moves the keys on the arcs it gains. A pool's workers are fixed, so its ring is built once when
+// the pool starts, and the keys of a dead worker are passed over to the following nodes, see locateLive.
+type hashRing struct {
+	points []ringPoint
+}
+
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	// fnv barely changes the high bits for keys differing only in their last bytes (e.g. "tenant-1", "tenant-2"), so
+	// the hash is mixed to spread such keys around the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (r *hashRing) add(node int) {
+	for i := 0; i < ringReplicas; i++ {
+		r.points = append(r.points, ringPoint{hash: ringHash(fmt.Sprintf("%v-%v", node, i)), node: node})
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.node, b.node))
+	})
+}
+
+// locate returns the node owning key, or -1 if the ring is empty.
+func (r *hashRing) locate(key string) int {
+	return r.locateLive(key, func(int) bool { return true })
+}
+
+// locateLive returns the first node accepted by live going round the ring from key, so the keys of a node that isn't
+// live are spread over the nodes following its points while the others keep theirs. If no node is live the node owning
+// key is returned, and -1 if the ring is empty.
+func (r *hashRing) locateLive(key string, live func(node int) bool) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+	h := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	for i := range len(r.points) {
+		if node := r.points[(start+i)%len(r.points)].node; live(node) {
+			return node
+		}
+	}
+	return r.points[start%len(r.points)].node
+}
+
+// acquireCall waits for a worker for the call, restricted to the worker owning the call's affinity key if it has one.
+// While that worker is dead the key is routed to the next live worker on the ring.
+func (p *Pool) acquireCall(ctx context.Context, cfg callConfig) (*PythonWrapper, error) {
+	if !cfg.hasAffinity {
+		return p.acquire(ctx, cfg.priority)
+	}
+	p.mu.Lock()
+	empty := p.ring.locate(cfg.affinity) == -1
+	p.mu.Unlock()
+	if empty {
+		return p.acquire(ctx, cfg.priority)
+	}
+	// the key's worker is looked up on each check since workers may die, be replaced or restart while the call waits
+	return p.acquireMatching(ctx, cfg.priority, func(w *PythonWrapper) bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for w.replacedBy != nil {
+			w = w.replacedBy
+		}
+		node := p.ring.locateLive(cfg.affinity, func(node int) bool { return p.workers[node].alive() })
+		return p.workers[node] == w
+	})
+}
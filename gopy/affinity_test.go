@@ -0,0 +1,47 @@
+package gopy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	var r hashRing
+	if got := r.locate("key"); got != -1 {
+		t.Fatalf("locate() on empty ring = %v, want -1", got)
+	}
+	for node := 0; node < 4; node++ {
+		r.add(node)
+	}
+	keys := make([]string, 10000)
+	before := make([]int, len(keys))
+	counts := make([]int, 5)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("tenant-%v", i)
+		before[i] = r.locate(keys[i])
+		counts[before[i]]++
+	}
+	for node, n := range counts[:4] {
+		if n < len(keys)/8 {
+			t.Errorf("node %v owns %v of %v keys, want a fair share", node, n, len(keys))
+		}
+	}
+
+	r.add(4)
+	for i, key := range keys {
+		if got := r.locate(key); got != before[i] && got != 4 {
+			t.Fatalf("key %v moved from %v to %v after adding node 4", key, before[i], got)
+		}
+	}
+
+	// only the keys of a node that isn't live move
+	for i, key := range keys {
+		got := r.locateLive(key, func(node int) bool { return node != 1 && node != 4 })
+		if got == 1 || got == 4 || (got != before[i] && before[i] != 1) {
+			t.Fatalf("key %v moved from %v to %v with node 1 dead", key, before[i], got)
+		}
+	}
+	if got := r.locateLive(keys[0], func(int) bool { return false }); got != r.locate(keys[0]) {
+		t.Errorf("locateLive() with no live node = %v, want owner %v", got, r.locate(keys[0]))
+	}
+}
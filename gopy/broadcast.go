@@ -0,0 +1,133 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// BroadcastResult is the outcome of a broadcast call on one worker.
+type BroadcastResult[T any] struct {
+	Pid    int
+	Result T
+	Err    error
+}
+
+type broadcastEntry struct {
+	name  string
+	input msgpack.RawMessage
+}
+
+// broadcastLog keeps the latest broadcast input of each python function, in the order they were last broadcast, so
+// workers started after a broadcast can be brought to the same state.
+type broadcastLog struct {
+	mu      sync.Mutex
+	version uint64
+	entries []broadcastEntry
+}
+
+func (l *broadcastLog) record(name string, input msgpack.RawMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = slices.DeleteFunc(l.entries, func(e broadcastEntry) bool { return e.name == name })
+	l.entries = append(l.entries, broadcastEntry{name: name, input: input})
+	l.version++
+}
+
+func (l *broadcastLog) snapshot() (uint64, []broadcastEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.version, slices.Clone(l.entries)
+}
+
+func (l *broadcastLog) current() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.version
+}
+
+// Broadcast calls the python function on every live worker of the pool, including standby workers, waiting for a
+// free call slot on each, and returns the result of each worker. The latest input broadcast to each function is
+// replayed, in order of broadcast, on workers started later, e.g. restarted or replacement workers, before they take
+// calls. Broadcast functions may run more than once on a worker starting concurrently, so they should be idempotent. A
+// worker dying before it receives the broadcast gets an error result, its replacement receiving the input by replay.
+func Broadcast[T any](ctx context.Context, p *Pool, pythonFunctionName string, inputObj any) ([]BroadcastResult[T], error) {
+	data, err := msgpack.Marshal(inputObj)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialse broadcast input %v", err)
+	}
+	input := msgpack.RawMessage(data)
+	// recording and listing the workers under p.mu means a standby either receives the broadcast here or sees the new
+	// version when it is added, see startStandby
+	p.mu.Lock()
+	p.broadcasts.record(pythonFunctionName, input)
+	workers := slices.Clone(p.workers)
+	standby := slices.Clone(p.standby)
+	p.mu.Unlock()
+
+	results := make([]BroadcastResult[T], len(workers)+len(standby))
+	var wg sync.WaitGroup
+	call := func(i int, w *PythonWrapper, pinned bool) {
+		defer wg.Done()
+		proc, err := w.process()
+		if err != nil {
+			results[i].Err = err
+			return
+		}
+		if pinned {
+			// once the worker dies any slot is accepted, so the broadcast doesn't wait for a worker that was replaced
+			slot, err := p.acquireMatching(ctx, PriorityFromContext(ctx), func(other *PythonWrapper) bool {
+				return p.current(other) == w || proc.ctx.Err() != nil
+			})
+			if err != nil {
+				results[i].Err = fmt.Errorf("waiting for python worker: %w", err)
+				return
+			}
+			defer p.release(slot)
+			if p.current(slot) != w || proc.ctx.Err() != nil {
+				results[i].Err = fmt.Errorf("python worker died: %w", context.Cause(proc.ctx))
+				return
+			}
+		}
+		w.mu.Lock()
+		results[i].Pid = w.pid
+		w.mu.Unlock()
+		results[i].Result, results[i].Err = callProcess[T](ctx, proc, frameCall, pythonFunctionName, input)
+	}
+	n := 0
+	for _, w := range workers {
+		// dead workers receive the broadcast by replay when they are restarted or replaced
+		if w.alive() {
+			wg.Add(1)
+			go call(n, w, true)
+			n++
+		}
+	}
+	for _, w := range standby {
+		if w.alive() {
+			wg.Add(1)
+			go call(n, w, false)
+			n++
+		}
+	}
+	wg.Wait()
+	return results[:n], nil
+}
+
+// replayBroadcasts brings a newly started process up to date with the pool's broadcasts. Failures are logged rather
+// than failing the worker, so a broken broadcast can't stop workers from starting.
+func (w *PythonWrapper) replayBroadcasts(proc *process) {
+	if w.broadcasts == nil {
+		return
+	}
+	version, entries := w.broadcasts.snapshot()
+	for _, e := range entries {
+		if _, err := callProcess[any](w.parentCtx, proc, frameCall, e.name, e.input); err != nil {
+			slog.ErrorContext(w.parentCtx, fmt.Sprintf("replaying broadcast of %v to python worker: %v", e.name, err))
+		}
+	}
+	w.broadcastVer = version
+}
@@ -14,30 +14,6 @@ func int32ToBytes1D(src []int32, dest []byte) {
 	}
 }
 
-func int32ToBytes2D(s [][]int32, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 1 {
-		return
-	}
-	l := len(s[0]) * 4
-	i := 0
-	for _, row := range s {
-		int32ToBytes1D(row, dest[i:i+l])
-		i += l
-	}
-}
-
-func int32ToBytes3D(s [][][]int32, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 0 || len(s[0][0]) == 0 {
-		return
-	}
-	l := len(s[0]) * len(s[0][0]) * 4
-	i := 0
-	for _, dim := range s {
-		int32ToBytes2D(dim, dest[i:i+l])
-		i += l
-	}
-}
-
 func int16ToBytes1D(src []int16, dest []byte) {
 	i := 0
 	for _, val := range src {
@@ -46,30 +22,6 @@ func int16ToBytes1D(src []int16, dest []byte) {
 	}
 }
 
-func int16ToBytes2D(s [][]int16, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 1 {
-		return
-	}
-	l := len(s[0]) * 2
-	i := 0
-	for _, row := range s {
-		int16ToBytes1D(row, dest[i:i+l])
-		i += l
-	}
-}
-
-func int16ToBytes3D(s [][][]int16, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 0 || len(s[0][0]) == 0 {
-		return
-	}
-	l := len(s[0]) * len(s[0][0]) * 2
-	i := 0
-	for _, dim := range s {
-		int16ToBytes2D(dim, dest[i:i+l])
-		i += l
-	}
-}
-
 // Int64 versions
 func int64ToBytes1D(src []int64, dest []byte) {
 	i := 0
@@ -79,30 +31,6 @@ func int64ToBytes1D(src []int64, dest []byte) {
 	}
 }
 
-func int64ToBytes2D(s [][]int64, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 1 {
-		return
-	}
-	l := len(s[0]) * 8
-	i := 0
-	for _, row := range s {
-		int64ToBytes1D(row, dest[i:i+l])
-		i += l
-	}
-}
-
-func int64ToBytes3D(s [][][]int64, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 0 || len(s[0][0]) == 0 {
-		return
-	}
-	l := len(s[0]) * len(s[0][0]) * 8
-	i := 0
-	for _, dim := range s {
-		int64ToBytes2D(dim, dest[i:i+l])
-		i += l
-	}
-}
-
 // Float32 versions
 func float32ToBytes1D(src []float32, dest []byte) {
 	i := 0
@@ -112,30 +40,6 @@ func float32ToBytes1D(src []float32, dest []byte) {
 	}
 }
 
-func float32ToBytes2D(s [][]float32, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 1 {
-		return
-	}
-	l := len(s[0]) * 4
-	i := 0
-	for _, row := range s {
-		float32ToBytes1D(row, dest[i:i+l])
-		i += l
-	}
-}
-
-func float32ToBytes3D(s [][][]float32, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 0 || len(s[0][0]) == 0 {
-		return
-	}
-	l := len(s[0]) * len(s[0][0]) * 4
-	i := 0
-	for _, dim := range s {
-		float32ToBytes2D(dim, dest[i:i+l])
-		i += l
-	}
-}
-
 // Float64 versions
 func float64ToBytes1D(src []float64, dest []byte) {
 	i := 0
@@ -145,30 +49,6 @@ func float64ToBytes1D(src []float64, dest []byte) {
 	}
 }
 
-func float64ToBytes2D(s [][]float64, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 1 {
-		return
-	}
-	l := len(s[0]) * 8
-	i := 0
-	for _, row := range s {
-		float64ToBytes1D(row, dest[i:i+l])
-		i += l
-	}
-}
-
-func float64ToBytes3D(s [][][]float64, dest []byte) {
-	if len(s) == 0 || len(s[0]) == 0 || len(s[0][0]) == 0 {
-		return
-	}
-	l := len(s[0]) * len(s[0][0]) * 8
-	i := 0
-	for _, dim := range s {
-		float64ToBytes2D(dim, dest[i:i+l])
-		i += l
-	}
-}
-
 func bytesToInt161D(src []byte, result []int16) {
 	for i := 0; i < len(result); i++ {
 		result[i] = int16(binary.LittleEndian.Uint16(src[i*2 : i*2+2]))
@@ -199,12 +79,128 @@ func bytesToFloat641D(src []byte, result []float64) {
 	}
 }
 
-// ----  UNSAFE VERSIONS WIP ----
+// nativeLittleEndian is whether the host stores numbers little endian, the byte order arrays are transported in. If so
+// arrays are encoded and decoded by reinterpreting the memory of their elements as bytes rather than converting them
+// one by one.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
 
-func int32ToBytes1DUnsafe(s []int32) []byte {
+// elementBytes returns the memory of s as bytes without copying it.
+func elementBytes[T Element](s []T) []byte {
 	if len(s) == 0 {
-		return []byte{}
+		return nil
+	}
+	var zero T
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), len(s)*int(unsafe.Sizeof(zero)))
+}
+
+// elementsToBytes writes the elements little endian to dest, which must be large enough to hold them.
+func elementsToBytes[T Element](src []T, dest []byte) {
+	switch s := any(src).(type) {
+	case []bool:
+		for i, v := range s {
+			dest[i] = 0
+			if v {
+				dest[i] = 1
+			}
+		}
+	case []int8:
+		for i, v := range s {
+			dest[i] = byte(v)
+		}
+	case []uint8:
+		copy(dest, s)
+	case []int16:
+		int16ToBytes1D(s, dest)
+	case []uint16:
+		for i, v := range s {
+			binary.LittleEndian.PutUint16(dest[i*2:], v)
+		}
+	case []int32:
+		int32ToBytes1D(s, dest)
+	case []uint32:
+		for i, v := range s {
+			binary.LittleEndian.PutUint32(dest[i*4:], v)
+		}
+	case []int64:
+		int64ToBytes1D(s, dest)
+	case []uint64:
+		for i, v := range s {
+			binary.LittleEndian.PutUint64(dest[i*8:], v)
+		}
+	case []Float16:
+		for i, v := range s {
+			binary.LittleEndian.PutUint16(dest[i*2:], uint16(v))
+		}
+	case []float32:
+		float32ToBytes1D(s, dest)
+	case []float64:
+		float64ToBytes1D(s, dest)
+	case []complex64:
+		for i, v := range s {
+			binary.LittleEndian.PutUint32(dest[i*8:], math.Float32bits(real(v)))
+			binary.LittleEndian.PutUint32(dest[i*8+4:], math.Float32bits(imag(v)))
+		}
+	case []complex128:
+		for i, v := range s {
+			binary.LittleEndian.PutUint64(dest[i*16:], math.Float64bits(real(v)))
+			binary.LittleEndian.PutUint64(dest[i*16+8:], math.Float64bits(imag(v)))
+		}
+	}
+}
+
+// bytesToElements reads little endian elements from src into result.
+func bytesToElements[T Element](src []byte, result []T) {
+	switch r := any(result).(type) {
+	case []bool:
+		for i := range r {
+			r[i] = src[i] != 0
+		}
+	case []int8:
+		for i := range r {
+			r[i] = int8(src[i])
+		}
+	case []uint8:
+		copy(r, src)
+	case []int16:
+		bytesToInt161D(src, r)
+	case []uint16:
+		for i := range r {
+			r[i] = binary.LittleEndian.Uint16(src[i*2:])
+		}
+	case []int32:
+		bytesToInt321D(src, r)
+	case []uint32:
+		for i := range r {
+			r[i] = binary.LittleEndian.Uint32(src[i*4:])
+		}
+	case []int64:
+		bytesToInt641D(src, r)
+	case []uint64:
+		for i := range r {
+			r[i] = binary.LittleEndian.Uint64(src[i*8:])
+		}
+	case []Float16:
+		for i := range r {
+			r[i] = Float16(binary.LittleEndian.Uint16(src[i*2:]))
+		}
+	case []float32:
+		bytesToFloat321D(src, r)
+	case []float64:
+		bytesToFloat641D(src, r)
+	case []complex64:
+		for i := range r {
+			re := math.Float32frombits(binary.LittleEndian.Uint32(src[i*8:]))
+			im := math.Float32frombits(binary.LittleEndian.Uint32(src[i*8+4:]))
+			r[i] = complex(re, im)
+		}
+	case []complex128:
+		for i := range r {
+			re := math.Float64frombits(binary.LittleEndian.Uint64(src[i*16:]))
+			im := math.Float64frombits(binary.LittleEndian.Uint64(src[i*16+8:]))
+			r[i] = complex(re, im)
+		}
 	}
-	sliceHeader := unsafe.SliceData(s)
-	return *(*[]byte)(unsafe.Pointer(sliceHeader))
 }
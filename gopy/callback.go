@@ -0,0 +1,92 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"log/slog"
+	"sync"
+)
+
+// Handler is a go function python can call back with call_go(name, payload) while a call is in flight. payload is
+// the msgpack encoded payload passed to call_go, the returned value is sent back as call_go's result and a returned
+// error is raised in python as a gopyadapter.GoError.
+type Handler func(ctx context.Context, payload msgpack.RawMessage) (any, error)
+
+type handlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func newHandlerRegistry() *handlerRegistry {
+	return &handlerRegistry{handlers: make(map[string]Handler)}
+}
+
+func (r *handlerRegistry) call(ctx context.Context, name string, payload msgpack.RawMessage) (any, error) {
+	if r == nil {
+		return nil, fmt.Errorf("no go handler named %v", name)
+	}
+	r.mu.RLock()
+	h, ok := r.handlers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no go handler named %v", name)
+	}
+	return h(ctx, payload)
+}
+
+// Handle registers a go handler python running in the pool's workers can call by name with call_go. The handler runs
+// with the context of the go call during which python called it and may be called concurrently.
+func (p *Pool) Handle(name string, handler Handler) {
+	p.handlers.mu.Lock()
+	defer p.handlers.mu.Unlock()
+	p.handlers.handlers[name] = handler
+}
+
+// HandleFunc registers a typed go handler, see Pool.Handle. The payload passed to call_go is unmarshalled into I.
+func HandleFunc[I, O any](p *Pool, name string, fn func(context.Context, I) (O, error)) {
+	p.Handle(name, func(ctx context.Context, payload msgpack.RawMessage) (any, error) {
+		var input I
+		if err := msgpack.Unmarshal(payload, &input); err != nil {
+			return nil, fmt.Errorf("unmarshalling payload for go handler %v: %w", name, err)
+		}
+		return fn(ctx, input)
+	})
+}
+
+// handleCallback runs the go handler python asked for during the call and sends back its result.
+func (p *process) handleCallback(pc *pendingCall, f frame) {
+	defer func() {
+		// signalled before the count drops, so the call timeout can't expire in between
+		pc.touch()
+		pc.callbacks.Add(-1)
+	}()
+	var callbackID uint64
+	var name string
+	if err := f.field(0, &callbackID); err != nil {
+		p.cancelCause(fmt.Errorf("decoding callback id: %w", err))
+		return
+	}
+	if err := f.field(1, &name); err != nil {
+		p.writeCallbackError(pc, callbackID, fmt.Errorf("decoding go handler name: %w", err))
+		return
+	}
+	var payload msgpack.RawMessage
+	if len(f.fields) > 2 {
+		payload = f.fields[2]
+	}
+	result, err := p.handlers.call(pc.ctx, name, payload)
+	if err != nil {
+		p.writeCallbackError(pc, callbackID, err)
+		return
+	}
+	if err := p.write(frameCallbackResult, pc.id, callbackID, result); err != nil {
+		p.writeCallbackError(pc, callbackID, err)
+	}
+}
+
+func (p *process) writeCallbackError(pc *pendingCall, callbackID uint64, err error) {
+	if err := p.write(frameCallbackError, pc.id, callbackID, err.Error()); err != nil {
+		slog.DebugContext(p.ctx, fmt.Sprintf("sending go handler error for request %v: %v", pc.id, err))
+	}
+}
@@ -0,0 +1,109 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"log/slog"
+	"sync"
+)
+
+// eventBuffer is the number of events buffered per subscription before further events are dropped.
+const eventBuffer = 64
+
+// Event is published by python with gopyadapter's publish, independently of any call.
+type Event struct {
+	Topic   string
+	Payload msgpack.RawMessage
+}
+
+// Decode unmarshals the event's payload into v.
+func (e Event) Decode(v any) error {
+	return msgpack.Unmarshal(e.Payload, v)
+}
+
+type eventBus struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	subs   map[string]map[chan Event]struct{}
+	closed bool
+}
+
+func newEventBus(ctx context.Context) *eventBus {
+	return &eventBus{ctx: ctx, subs: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving the events python workers of the pool publish on topic, and a function to
+// cancel the subscription. Events are delivered without blocking the workers, so events are dropped for a subscriber
+// that falls more than 64 events behind. The channel is closed when the subscription is cancelled or the pool closed.
+func (p *Pool) Subscribe(topic string) (<-chan Event, func()) {
+	b := p.events
+	ch := make(chan Event, eventBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[chan Event]struct{})
+	}
+	b.subs[topic][ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { b.unsubscribe(topic, ch) })
+	}
+}
+
+func (b *eventBus) unsubscribe(topic string, ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[topic][ch]; !ok {
+		return
+	}
+	delete(b.subs[topic], ch)
+	if len(b.subs[topic]) == 0 {
+		delete(b.subs, topic)
+	}
+	close(ch)
+}
+
+func (b *eventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[e.Topic] {
+		select {
+		case ch <- e:
+		default:
+			slog.WarnContext(b.ctx, fmt.Sprintf("dropping python event on topic %v for slow subscriber", e.Topic))
+		}
+	}
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for topic, subs := range b.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subs, topic)
+	}
+}
+
+// publishEvent passes an event frame sent by python to the pool's subscribers.
+func (p *process) publishEvent(f frame) {
+	var e Event
+	if err := f.field(0, &e.Topic); err != nil {
+		slog.WarnContext(p.ctx, fmt.Sprintf("decoding python event topic: %v", err))
+		return
+	}
+	if len(f.fields) > 1 {
+		e.Payload = f.fields[1]
+	}
+	p.events.publish(e)
+}
@@ -0,0 +1,226 @@
+package gopy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// ndarrayFile flags an NDArray extension referring to an array stored in a file. The payload holds the offset of
+	// the array in the file (u64) and the path of the file in place of the elements.
+	ndarrayFile = 0x02
+	// ndarrayWritable flags a file array python maps read-write.
+	ndarrayWritable = 0x04
+)
+
+// FileArray refers to an array stored in a file, in C order with little endian elements, e.g. a dataset on disk. It is
+// passed to python in place of the array, which gopyadapter opens as a np.memmap, so the elements are never sent.
+// Python functions return one with gopyadapter's file_array, which Go can then Map or Read.
+type FileArray[T Element] struct {
+	Path  string
+	Shape []int
+	// Offset is the position of the first element in the file in bytes.
+	Offset int64
+	// Writable maps the array read-write in python, so changes made to it are written to the file.
+	Writable bool
+}
+
+// WriteFileArray writes a to the file at path, replacing it, and returns a reference to the array in the file.
+func WriteFileArray[T Element](path string, a NDArray[T]) (FileArray[T], error) {
+	if err := a.validate(); err != nil {
+		return FileArray[T]{}, err
+	}
+	if err := os.WriteFile(path, a.AsOrder(OrderC).littleEndianBytes(), 0o644); err != nil {
+		return FileArray[T]{}, fmt.Errorf("writing array file: %w", err)
+	}
+	return FileArray[T]{Path: path, Shape: a.Shape}, nil
+}
+
+func (f FileArray[T]) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if _, err := f.size(); err != nil {
+		return err
+	}
+	// python may run in another working directory
+	path, err := filepath.Abs(f.Path)
+	if err != nil {
+		return err
+	}
+	flags := byte(ndarrayFile)
+	if f.Writable {
+		flags |= ndarrayWritable
+	}
+	code, _ := dtypeOf[T]()
+	header := encodeNDArrayHeader(f.Shape, flags)
+	header = binary.LittleEndian.AppendUint64(header, uint64(f.Offset))
+	if err := enc.EncodeExtHeader(int8(ExtNDArray+code), len(header)+len(path)); err != nil {
+		return err
+	}
+	if _, err := enc.Writer().Write(header); err != nil {
+		return err
+	}
+	_, err = enc.Writer().Write([]byte(path))
+	return err
+}
+
+// DecodeMsgpack decodes a reference to an array in a file returned by python. Arrays passed by value can't be decoded
+// into a FileArray.
+func (f *FileArray[T]) DecodeMsgpack(dec *msgpack.Decoder) error {
+	extID, extLen, err := dec.DecodeExtHeader()
+	if err != nil {
+		return err
+	}
+	code, _ := dtypeOf[T]()
+	if int(extID) != ExtNDArray+code {
+		return fmt.Errorf("msgpack: got ext type=%v, wanted %v", extID, ExtNDArray+code)
+	}
+	flags, shape, rest, err := decodeNDArrayHeader(dec, extLen)
+	if err != nil {
+		return err
+	}
+	if flags&ndarrayFile == 0 {
+		return fmt.Errorf("array of shape %v was passed by value, not as a file reference", shape)
+	}
+	*f = FileArray[T]{Shape: shape}
+	return f.decodeRef(dec, flags, rest)
+}
+
+// decodeRef decodes the offset and path of the payload of a file array of length refLen following the shape.
+func (f *FileArray[T]) decodeRef(dec *msgpack.Decoder, flags byte, refLen int) error {
+	if refLen < 8 {
+		return fmt.Errorf("invalid file array: %v bytes is too short for its offset", refLen)
+	}
+	ref := make([]byte, refLen)
+	if err := dec.ReadFull(ref); err != nil {
+		return err
+	}
+	f.Offset = int64(binary.LittleEndian.Uint64(ref))
+	f.Path = string(ref[8:])
+	f.Writable = flags&ndarrayWritable != 0
+	return nil
+}
+
+// size checks the shape and offset of the array and returns its number of elements.
+func (f FileArray[T]) size() (int, error) {
+	_, size := dtypeOf[T]()
+	n, _, err := arrayBytes(f.Shape, size)
+	if err != nil {
+		return 0, err
+	}
+	if f.Offset < 0 {
+		return 0, fmt.Errorf("invalid file array offset %v", f.Offset)
+	}
+	return n, nil
+}
+
+// open opens the file and checks it holds the array.
+func (f FileArray[T]) open() (*os.File, int, error) {
+	n, err := f.size()
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening array file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("opening array file: %w", err)
+	}
+	_, size := dtypeOf[T]()
+	if f.Offset > info.Size() || info.Size()-f.Offset < int64(n*size) {
+		file.Close()
+		return nil, 0, fmt.Errorf("array file %v of %v bytes is too short for array of shape %v at offset %v",
+			f.Path, info.Size(), f.Shape, f.Offset)
+	}
+	return file, n, nil
+}
+
+// readAll reads the elements of the array into dest.
+func (f FileArray[T]) readAll(dest []byte) error {
+	file, n, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, size := dtypeOf[T](); len(dest) != n*size {
+		return fmt.Errorf("array of %v bytes read into %v bytes", n*size, len(dest))
+	}
+	if _, err := io.ReadFull(io.NewSectionReader(file, f.Offset, int64(len(dest))), dest); err != nil {
+		return fmt.Errorf("reading array file: %w", err)
+	}
+	return nil
+}
+
+// Read reads the array from the file into memory.
+func (f FileArray[T]) Read() (NDArray[T], error) {
+	n, err := f.size()
+	if err != nil {
+		return NDArray[T]{}, err
+	}
+	code, size := dtypeOf[T]()
+	a := NDArray[T]{Data: make([]T, n), Shape: f.Shape}
+	if nativeLittleEndian && code != dtypeBool {
+		return a, f.readAll(elementBytes(a.Data))
+	}
+	data := make([]byte, n*size)
+	if err := f.readAll(data); err != nil {
+		return NDArray[T]{}, err
+	}
+	bytesToElements(data, a.Data)
+	return a, nil
+}
+
+// MappedArray is an array whose elements are the memory mapped contents of a file, see FileArray.Map. The elements are
+// read-only, writing them crashes the program, and must not be used once the array is closed.
+type MappedArray[T Element] struct {
+	NDArray[T]
+	mapping []byte
+}
+
+// Close unmaps the file.
+func (m *MappedArray[T]) Close() error {
+	if m.mapping == nil {
+		return nil
+	}
+	mapping := m.mapping
+	m.mapping, m.Data = nil, nil
+	return syscall.Munmap(mapping)
+}
+
+// Map maps the array read-only into memory, so its elements are paged in from the file as they are used rather than
+// read upfront. The array is read into memory instead where it can't be used as is: on big endian hosts, for bools and
+// for offsets that aren't aligned to the element type.
+func (f FileArray[T]) Map() (*MappedArray[T], error) {
+	code, size := dtypeOf[T]()
+	var zero T
+	if !nativeLittleEndian || code == dtypeBool || f.Offset%int64(unsafe.Alignof(zero)) != 0 {
+		a, err := f.Read()
+		if err != nil {
+			return nil, err
+		}
+		return &MappedArray[T]{NDArray: a}, nil
+	}
+	file, n, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if n == 0 {
+		return &MappedArray[T]{NDArray: NDArray[T]{Data: []T{}, Shape: f.Shape}}, nil
+	}
+	// mappings start at a page boundary
+	start := f.Offset &^ int64(os.Getpagesize()-1)
+	mapping, err := syscall.Mmap(int(file.Fd()), start, int(f.Offset-start)+n*size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping array file: %w", err)
+	}
+	data := unsafe.Slice((*T)(unsafe.Pointer(&mapping[f.Offset-start])), n)
+	return &MappedArray[T]{NDArray: NDArray[T]{Data: data, Shape: f.Shape}, mapping: mapping}, nil
+}
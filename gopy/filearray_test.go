@@ -0,0 +1,53 @@
+package gopy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileArrayMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "array.bin")
+	a := NDArray[int32]{Data: []int32{1, 2, 3, 4, 5, 6}, Shape: []int{3, 2}}
+	if _, err := WriteFileArray(path, a); err != nil {
+		t.Fatalf("WriteFileArray() error = %v", err)
+	}
+	// the array after a 4 byte header, mapped from the start of the page
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, append([]byte{9, 9, 9, 9}, data...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := FileArray[int32]{Path: path, Shape: []int{2, 2}, Offset: 8}
+	want := NDArray[int32]{Data: []int32{2, 3, 4, 5}, Shape: []int{2, 2}}
+	m, err := f.Map()
+	if err != nil {
+		t.Fatalf("Map() error = %v", err)
+	}
+	if !reflect.DeepEqual(m.NDArray, want) {
+		t.Errorf("Map() = %v, want %v", m.NDArray, want)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if got, err := f.Read(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %v, %v, want %v", got, err, want)
+	}
+
+	// offsets not aligned to the elements are read instead
+	f.Offset = 6
+	if m, err := f.Map(); err != nil || m.mapping != nil || len(m.Data) != 4 {
+		t.Errorf("Map() with unaligned offset = %v, %v, want an array read into memory", m, err)
+	}
+
+	for name, f := range map[string]FileArray[int32]{
+		"too short":       {Path: path, Shape: []int{4, 2}},
+		"negative offset": {Path: path, Shape: []int{1}, Offset: -1},
+		"negative shape":  {Path: path, Shape: []int{-1}},
+		"missing file":    {Path: path + ".missing", Shape: []int{1}},
+	} {
+		if _, err := f.Map(); err == nil {
+			t.Errorf("Map() with %v succeeded", name)
+		}
+	}
+}
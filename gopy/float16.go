@@ -0,0 +1,71 @@
+package gopy
+
+import (
+	"math"
+	"strconv"
+)
+
+// Float16 is an IEEE 754 half precision float, the element type of numpy float16 arrays. Go has no arithmetic on it,
+// convert with Float32 and Float16From.
+type Float16 uint16
+
+// Float16From returns the half precision float nearest to f, rounding ties to even. Values too large for a half
+// precision float become infinite.
+func Float16From(f float32) Float16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23) & 0xff
+	mant := b & 0x7fffff
+	if exp == 0xff {
+		if mant != 0 {
+			return Float16(sign | 0x7e00)
+		}
+		return Float16(sign | 0x7c00)
+	}
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return Float16(sign | 0x7c00)
+	}
+	if e <= 0 {
+		// subnormal in half precision, or too small and rounded to zero
+		if e < -10 {
+			return Float16(sign)
+		}
+		mant |= 0x800000
+		shift := uint(14 - e)
+		half := mant >> shift
+		rem, halfway := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > halfway || (rem == halfway && half&1 == 1) {
+			half++
+		}
+		return Float16(sign | uint16(half))
+	}
+	// rounding up may carry into the exponent, which is still the correctly rounded value
+	half := uint32(e)<<10 | mant>>13
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++
+	}
+	return Float16(sign | uint16(half))
+}
+
+// Float32 returns h as a float32, which represents every half precision value exactly.
+func (h Float16) Float32() float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+func (h Float16) String() string {
+	return strconv.FormatFloat(float64(h.Float32()), 'g', -1, 32)
+}
@@ -0,0 +1,36 @@
+package gopy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		in   float32
+		bits Float16
+		out  float32
+	}{
+		{0, 0x0000, 0},
+		{1, 0x3c00, 1},
+		{-2, 0xc000, -2},
+		{65504, 0x7bff, 65504},
+		{65520, 0x7c00, float32(math.Inf(1))},
+		{1.0 / (1 << 24), 0x0001, 1.0 / (1 << 24)},
+		{1.0 / (1 << 25), 0x0000, 0},
+		{1 + 1.0/2048, 0x3c00, 1},
+		{1 + 3.0/2048, 0x3c02, 1 + 2.0/1024},
+		{float32(math.Inf(-1)), 0xfc00, float32(math.Inf(-1))},
+	}
+	for _, tt := range tests {
+		if got := Float16From(tt.in); got != tt.bits {
+			t.Errorf("Float16From(%v) = %#04x, want %#04x", tt.in, uint16(got), uint16(tt.bits))
+		}
+		if got := tt.bits.Float32(); got != tt.out {
+			t.Errorf("Float16(%#04x).Float32() = %v, want %v", uint16(tt.bits), got, tt.out)
+		}
+	}
+	if nan := Float16From(float32(math.NaN())); !math.IsNaN(float64(nan.Float32())) {
+		t.Errorf("Float16From(NaN) = %#04x, want NaN", uint16(nan))
+	}
+}
@@ -0,0 +1,237 @@
+package gopy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jptrs93/goutil/cmdu"
+	"github.com/jptrs93/goutil/contextu"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// forkServer manages a template python process (gopyadapter.forkserver) that imports the configured modules once and
+// then forks worker processes on request, so workers start with those modules already loaded and share their memory
+// copy-on-write.
+//
+// The fork server is controlled over a unix socket. A fork request carries the worker's communication pipes and
+// stdout/stderr as file descriptors, the fork server replies with the pid of the forked worker and later reports when
+// that worker exits, since only the fork server, as its parent, can wait on it.
+type forkServer struct {
+	ctx         context.Context
+	cancelCause context.CancelCauseFunc
+	conn        *net.UnixConn
+	// requestMu serialises fork requests so replies can be matched to them
+	requestMu sync.Mutex
+	replies   chan forkReply
+	exitsMu   sync.Mutex
+	exits     map[int]chan error
+}
+
+type forkReply struct {
+	pid    int
+	exited chan error
+}
+
+// forkServerStartTimeout bounds how long preloading modules in the fork server may take.
+const forkServerStartTimeout = 5 * time.Minute
+
+func startForkServer(ctx context.Context, executablePath, workingDir, entryScript string, preloadModules, env []string) (*forkServer, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("creating fork server socket: %w", err)
+	}
+	thisEnd := os.NewFile(uintptr(fds[0]), "forkserver")
+	otherEnd := os.NewFile(uintptr(fds[1]), "forkserver-child")
+	defer otherEnd.Close()
+	fc, err := net.FileConn(thisEnd)
+	_ = thisEnd.Close()
+	if err != nil {
+		return nil, fmt.Errorf("creating fork server connection: %w", err)
+	}
+
+	ctx, cancelCauseFunc := context.WithCancelCause(ctx)
+	fs := &forkServer{
+		ctx:         ctx,
+		cancelCause: cancelCauseFunc,
+		conn:        fc.(*net.UnixConn),
+		replies:     make(chan forkReply),
+		exits:       make(map[int]chan error),
+	}
+	contextu.OnCancel(ctx, func() { _ = fs.conn.Close() })
+
+	args := append([]string{"-m", "gopyadapter.forkserver", entryScript}, preloadModules...)
+	cmd := exec.Command(executablePath, args...)
+	cmd.Dir = workingDir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.ExtraFiles = []*os.File{otherEnd}
+	slog.DebugContext(ctx, fmt.Sprintf("start fork server process: working dir: %v, executable: %v, preload: %v", cmd.Dir, executablePath, preloadModules))
+
+	stdout, stderr, _, closeFunc, err := cmdu.InitStdPipes(cmd)
+	if err != nil {
+		cancelCauseFunc(fmt.Errorf("failed initialising fork server stdout/stderr: %w", err))
+		return nil, context.Cause(ctx)
+	}
+	contextu.OnCancel(ctx, closeFunc)
+	go consumeStdout(ctx, stdout)
+	go consumeStderr(ctx, stderr)
+	if err := cmd.Start(); err != nil {
+		cancelCauseFunc(fmt.Errorf("failed to start fork server process: %w", err))
+		return nil, context.Cause(ctx)
+	}
+	contextu.OnCancel(ctx, func() { _ = cmd.Process.Kill() })
+	go func() {
+		err := cmd.Wait()
+		if err == nil {
+			err = errors.New("python fork server exited")
+		}
+		cancelCauseFunc(fmt.Errorf("exit error from python fork server: %w", err))
+	}()
+
+	// the fork server signals it has finished preloading modules with a single 'R' message
+	_ = fs.conn.SetReadDeadline(time.Now().Add(forkServerStartTimeout))
+	buf := make([]byte, 16)
+	n, err := fs.conn.Read(buf)
+	if err != nil || n != 1 || buf[0] != 'R' {
+		cancelCauseFunc(fmt.Errorf("failed to read ready signal from python fork server: %v", err))
+		return nil, context.Cause(ctx)
+	}
+	_ = fs.conn.SetReadDeadline(time.Time{})
+	go fs.readMessages()
+	slog.InfoContext(ctx, "successfully initialised python fork server")
+	return fs, nil
+}
+
+// readMessages dispatches fork replies and worker exit notifications sent by the fork server.
+func (fs *forkServer) readMessages() {
+	buf := make([]byte, 16)
+	for {
+		n, err := fs.conn.Read(buf)
+		if err != nil {
+			fs.cancelCause(fmt.Errorf("reading from python fork server: %w", err))
+			break
+		}
+		switch {
+		case n == 5 && buf[0] == 'P':
+			pid := int(int32(binary.LittleEndian.Uint32(buf[1:5])))
+			exited := make(chan error, 1)
+			fs.exitsMu.Lock()
+			fs.exits[pid] = exited
+			fs.exitsMu.Unlock()
+			select {
+			case fs.replies <- forkReply{pid: pid, exited: exited}:
+			case <-fs.ctx.Done():
+			}
+		case n == 9 && buf[0] == 'X':
+			pid := int(int32(binary.LittleEndian.Uint32(buf[1:5])))
+			code := int(int32(binary.LittleEndian.Uint32(buf[5:9])))
+			fs.exitsMu.Lock()
+			exited, ok := fs.exits[pid]
+			delete(fs.exits, pid)
+			fs.exitsMu.Unlock()
+			if !ok {
+				continue
+			}
+			if code != 0 {
+				exited <- fmt.Errorf("python process ended with bad exit code %v", code)
+			} else {
+				exited <- nil
+			}
+		default:
+			slog.WarnContext(fs.ctx, fmt.Sprintf("unexpected message from python fork server: %q", buf[:n]))
+		}
+	}
+	// workers forked by a dead fork server can no longer be waited on, report them as lost
+	fs.exitsMu.Lock()
+	defer fs.exitsMu.Unlock()
+	for pid, exited := range fs.exits {
+		exited <- fmt.Errorf("python fork server exited: %w", context.Cause(fs.ctx))
+		delete(fs.exits, pid)
+	}
+}
+
+// fork asks the fork server for a new worker process using the given files as its communication pipes (fd 3 and 4),
+// stdout and stderr. It returns the worker's pid and a channel receiving the result of the worker exiting.
+func (fs *forkServer) fork(files ...*os.File) (int, <-chan error, error) {
+	fs.requestMu.Lock()
+	defer fs.requestMu.Unlock()
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	if _, _, err := fs.conn.WriteMsgUnix([]byte("fork"), syscall.UnixRights(fds...), nil); err != nil {
+		return 0, nil, fmt.Errorf("sending fork request to python fork server: %w", err)
+	}
+	select {
+	case r := <-fs.replies:
+		return r.pid, r.exited, nil
+	case <-fs.ctx.Done():
+		return 0, nil, fmt.Errorf("python fork server exited: %w", context.Cause(fs.ctx))
+	}
+}
+
+func (fs *forkServer) Close() {
+	fs.cancelCause(nil)
+}
+
+// startForked starts the worker by forking it from the pool's fork server.
+func (w *PythonWrapper) startForked(ctx context.Context, cancelCauseFunc context.CancelCauseFunc, com cmdu.PipeCommunication) (int, error) {
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		com.CloseAndSwallowErrors()
+		cancelCauseFunc(fmt.Errorf("failed initialising forked process stdout: %w", err))
+		return 0, context.Cause(ctx)
+	}
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		com.CloseAndSwallowErrors()
+		_ = stdoutRead.Close()
+		_ = stdoutWrite.Close()
+		cancelCauseFunc(fmt.Errorf("failed initialising forked process stderr: %w", err))
+		return 0, context.Cause(ctx)
+	}
+	contextu.OnCancel(ctx, func() {
+		_ = stdoutRead.Close()
+		_ = stderrRead.Close()
+	})
+	go consumeStdout(ctx, stdoutRead)
+	go consumeStderr(ctx, stderrRead)
+
+	slog.DebugContext(ctx, fmt.Sprintf("fork worker process: script: %v", w.scriptPath))
+	pid, exited, err := w.forkServer.fork(com.OtherRead, com.OtherWrite, stdoutWrite, stderrWrite)
+	// the forked child holds its own copies, closing ours lets reads fail fast if the child dies
+	_ = com.OtherRead.Close()
+	_ = com.OtherWrite.Close()
+	_ = stdoutWrite.Close()
+	_ = stderrWrite.Close()
+	if err != nil {
+		com.CloseAndSwallowErrors()
+		cancelCauseFunc(fmt.Errorf("failed to fork python process: %w", err))
+		return 0, context.Cause(ctx)
+	}
+
+	var hasExited atomic.Bool
+	contextu.OnCancel(ctx, func() {
+		// the forked process is not our child, only signal it while the fork server has not reported it exited
+		if !hasExited.Load() {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}, com.CloseAndSwallowErrors)
+
+	go func() {
+		select {
+		case err := <-exited:
+			hasExited.Store(true)
+			cancelCauseFunc(err)
+		case <-ctx.Done():
+		}
+	}()
+	return pid, nil
+}
@@ -0,0 +1,175 @@
+package gopy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// largeWrite is the size from which writes to a frameBuffer are kept by reference rather than copied.
+const largeWrite = 32 << 10
+
+// frameBuffer collects an encoded frame before it is written to the pipe. Small writes are copied into the buffer,
+// while large writes, such as the memory of an array, are kept by reference and written to the pipe straight from the
+// caller's memory, so large frames are never copied as a whole. The memory of large writes must not change until the
+// frame is written.
+type frameBuffer struct {
+	segments [][]byte
+	// whether the last segment belongs to the buffer and may be appended to
+	owned bool
+	size  int
+	// set to pass large arrays in shared memory, shared holds the paths of the segments created for the frame
+	sharedMemory *sharedMemory
+	shared       []string
+}
+
+func (b *frameBuffer) Write(p []byte) (int, error) {
+	b.size += len(p)
+	if len(p) >= largeWrite {
+		b.segments = append(b.segments, p)
+		b.owned = false
+		return len(p), nil
+	}
+	b.appendOwned(p...)
+	return len(p), nil
+}
+
+func (b *frameBuffer) WriteByte(c byte) error {
+	b.size++
+	b.appendOwned(c)
+	return nil
+}
+
+func (b *frameBuffer) appendOwned(p ...byte) {
+	if !b.owned {
+		b.segments = append(b.segments, make([]byte, 0, 256))
+		b.owned = true
+	}
+	last := len(b.segments) - 1
+	b.segments[last] = append(b.segments[last], p...)
+}
+
+// share places data in a shared memory segment if sharing is enabled and data is large enough, returning the path of
+// the segment.
+func (b *frameBuffer) share(data []byte) (string, bool, error) {
+	if b.sharedMemory == nil || len(data) == 0 || len(data) < b.sharedMemory.threshold {
+		return "", false, nil
+	}
+	path, err := b.sharedMemory.create(data)
+	if err != nil {
+		return "", false, err
+	}
+	b.shared = append(b.shared, path)
+	return path, true, nil
+}
+
+// WriteTo writes the frame to w prefixed with its length as a big endian uint32.
+func (b *frameBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.size > math.MaxUint32 {
+		return 0, fmt.Errorf("frame of %v bytes exceeds the maximum frame size", b.size)
+	}
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(b.size))
+	n, err := w.Write(prefix[:])
+	total := int64(n)
+	for _, s := range b.segments {
+		if err != nil {
+			break
+		}
+		n, err = w.Write(s)
+		total += int64(n)
+	}
+	return total, err
+}
+
+// msgpackValueEnd returns the position in data just after the msgpack value starting at pos, which lets the fields of
+// a frame be sliced out of it without decoding or copying them. If visitExt isn't nil it is called with the type and
+// payload of every extension in the value.
+func msgpackValueEnd(data []byte, pos int, visitExt func(extID int8, payload []byte)) (int, error) {
+	if pos >= len(data) {
+		return 0, fmt.Errorf("msgpack value at %v is past the end of %v bytes", pos, len(data))
+	}
+	c := data[pos]
+	// size of the length that follows the code, size of fixed data after it, number of nested values
+	var lenSize, fixed, nested int
+	switch {
+	case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return pos + 1, nil
+	case c >= 0x80 && c <= 0x8f:
+		nested = 2 * int(c&0x0f)
+	case c >= 0x90 && c <= 0x9f:
+		nested = int(c & 0x0f)
+	case c >= 0xa0 && c <= 0xbf:
+		fixed = int(c & 0x1f)
+	case c == 0xc4 || c == 0xd9:
+		lenSize = 1
+	case c == 0xc5 || c == 0xda:
+		lenSize = 2
+	case c == 0xc6 || c == 0xdb:
+		lenSize = 4
+	case c == 0xc7:
+		lenSize, fixed = 1, 1
+	case c == 0xc8:
+		lenSize, fixed = 2, 1
+	case c == 0xc9:
+		lenSize, fixed = 4, 1
+	case c == 0xca:
+		fixed = 4
+	case c == 0xcb:
+		fixed = 8
+	case c >= 0xcc && c <= 0xd3:
+		fixed = 1 << ((c - 0xcc) % 4)
+	case c >= 0xd4 && c <= 0xd8:
+		fixed = 1 + 1<<(c-0xd4)
+	case c == 0xdc:
+		lenSize = 2
+	case c == 0xdd:
+		lenSize = 4
+	case c == 0xde:
+		lenSize = 2
+	case c == 0xdf:
+		lenSize = 4
+	default:
+		return 0, fmt.Errorf("invalid msgpack code 0x%x at %v", c, pos)
+	}
+	pos++
+	isExt := (c >= 0xc7 && c <= 0xc9) || (c >= 0xd4 && c <= 0xd8)
+	if lenSize > 0 {
+		if pos+lenSize > len(data) {
+			return 0, fmt.Errorf("msgpack length at %v is past the end of %v bytes", pos, len(data))
+		}
+		var n uint64
+		for _, b := range data[pos : pos+lenSize] {
+			n = n<<8 | uint64(b)
+		}
+		pos += lenSize
+		switch c {
+		case 0xdc, 0xdd:
+			nested = int(n)
+		case 0xde, 0xdf:
+			nested = 2 * int(n)
+		default:
+			if n > uint64(len(data)) {
+				return 0, fmt.Errorf("msgpack value of %v bytes is past the end of %v bytes", n, len(data))
+			}
+			fixed += int(n)
+		}
+	}
+	if fixed > len(data)-pos {
+		return 0, fmt.Errorf("msgpack value at %v is past the end of %v bytes", pos, len(data))
+	}
+	if isExt && visitExt != nil {
+		// the extension type is the first byte after the length, followed by the payload
+		visitExt(int8(data[pos]), data[pos+1:pos+fixed])
+	}
+	pos += fixed
+	for i := 0; i < nested; i++ {
+		end, err := msgpackValueEnd(data, pos, visitExt)
+		if err != nil {
+			return 0, err
+		}
+		pos = end
+	}
+	return pos, nil
+}
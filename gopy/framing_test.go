@@ -0,0 +1,154 @@
+package gopy
+
+import (
+	"bytes"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFrameEncoding(t *testing.T) {
+	large := NewNDArray[float64](largeWrite/8 + 1)
+	large.Data[0] = 1.5
+	fields := []any{
+		nil, true, 7, -3, 300, -70000, 1 << 40, 2.5, float32(1.5),
+		"", "short", strings.Repeat("x", 300), strings.Repeat("y", 70000),
+		[]byte{1, 2, 3}, []any{1, []any{"a", nil}}, map[string]any{"b": []int{1, 2}},
+		NDArray[int8]{Data: []int8{1}, Shape: []int{}}, large,
+	}
+	var b frameBuffer
+	if err := encodeFrame(&b, frameCall, 42, fields...); err != nil {
+		t.Fatalf("encodeFrame() error = %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := b.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	data := out.Bytes()
+	if n := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3]); n != len(data)-4 {
+		t.Fatalf("frame length prefix = %v, want %v", n, len(data)-4)
+	}
+	f, err := decodeFrame(data[4:])
+	if err != nil {
+		t.Fatalf("decodeFrame() error = %v", err)
+	}
+	if f.kind != frameCall || f.id != 42 || len(f.fields) != len(fields) {
+		t.Fatalf("decodeFrame() = kind %v, id %v, %v fields", f.kind, f.id, len(f.fields))
+	}
+	for i, want := range fields {
+		wantData, err := msgpack.Marshal(want)
+		if err != nil {
+			t.Fatalf("Marshal(%v) error = %v", want, err)
+		}
+		if !bytes.Equal(f.fields[i], wantData) {
+			t.Errorf("field %v = %x, want %x", i, f.fields[i][:min(len(f.fields[i]), 32)], wantData[:min(len(wantData), 32)])
+		}
+	}
+	var got NDArray[float64]
+	if err := f.field(len(fields)-1, &got); err != nil || !reflect.DeepEqual(got, large) {
+		t.Errorf("field(%v) = %v, %v", len(fields)-1, got.Shape, err)
+	}
+
+	if _, err := decodeFrame(data[4 : len(data)-1]); err == nil {
+		t.Errorf("decodeFrame() of a truncated frame error = nil, want error")
+	}
+}
+
+func benchmarkArray(b *testing.B, zeroCopy bool, run func(a NDArray[float64])) {
+	defer func(v bool) { nativeLittleEndian = v }(nativeLittleEndian)
+	if zeroCopy && !nativeLittleEndian {
+		b.Skip("zero copy arrays need a little endian host")
+	}
+	nativeLittleEndian = zeroCopy
+	a := NewNDArray[float64](100<<20/8/1000, 1000)
+	for i := range a.Data {
+		a.Data[i] = float64(i)
+	}
+	b.SetBytes(100 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		run(a)
+	}
+}
+
+// BenchmarkArrayEncode writes a frame holding a 100MB array, as sent to a worker.
+func BenchmarkArrayEncode(b *testing.B) {
+	encode := func(a NDArray[float64]) {
+		var buf frameBuffer
+		if err := encodeFrame(&buf, frameCall, 1, "f", a); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := buf.WriteTo(discard{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("zero-copy", func(b *testing.B) { benchmarkArray(b, true, encode) })
+	b.Run("element-wise", func(b *testing.B) { benchmarkArray(b, false, encode) })
+}
+
+// BenchmarkArrayDecode decodes a frame holding a 100MB array, as received from a worker.
+func BenchmarkArrayDecode(b *testing.B) {
+	var data []byte
+	decode := func(a NDArray[float64]) {
+		if data == nil {
+			var buf frameBuffer
+			var out bytes.Buffer
+			if err := encodeFrame(&buf, frameResult, 1, a); err != nil {
+				b.Fatal(err)
+			}
+			_, _ = buf.WriteTo(&out)
+			data = out.Bytes()[4:]
+		}
+		f, err := decodeFrame(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var got NDArray[float64]
+		if err := f.field(0, &got); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("zero-copy", func(b *testing.B) { benchmarkArray(b, true, decode) })
+	b.Run("element-wise", func(b *testing.B) { benchmarkArray(b, false, decode) })
+}
+
+// discard stands in for the pipe, io.Discard would be faster than any real write.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestFrameSharedMemory(t *testing.T) {
+	shared := newSharedMemory(1024, 0)
+	defer shared.removeAll()
+	large := NewNDArray[int16](4, 1000)
+	large.Data[3999] = 7
+	b := frameBuffer{sharedMemory: shared}
+	if err := encodeFrame(&b, frameResult, 1, NDArray[int16]{Data: []int16{1}, Shape: []int{1}}, large); err != nil {
+		t.Fatalf("encodeFrame() error = %v", err)
+	}
+	if len(b.shared) != 1 || b.size > 1024 {
+		t.Fatalf("encodeFrame() shared %v segments in a frame of %v bytes, want the large array shared", len(b.shared), b.size)
+	}
+	var out bytes.Buffer
+	if _, err := b.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	f, err := decodeFrame(out.Bytes()[4:])
+	if err != nil || !reflect.DeepEqual(f.shared, b.shared) {
+		t.Fatalf("decodeFrame() shared = %v, %v, want %v", f.shared, err, b.shared)
+	}
+	var got NDArray[int16]
+	if err := f.field(1, &got); err != nil || !reflect.DeepEqual(got, large) {
+		t.Errorf("field(1) = %v, %v", got.Shape, err)
+	}
+	if _, err := os.Stat(b.shared[0]); !os.IsNotExist(err) {
+		t.Errorf("segment %v still exists after decoding, stat error = %v", b.shared[0], err)
+	}
+	if err := f.field(1, &got); err == nil {
+		t.Errorf("decoding a removed segment error = nil, want error")
+	}
+}
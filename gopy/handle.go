@@ -0,0 +1,112 @@
+package gopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+)
+
+// ErrHandleReleased is returned when calling a method through a Handle that has been released.
+var ErrHandleReleased = errors.New("python object handle released")
+
+// Handle refers to a python object kept in the worker that created it, returned by a python function wrapping its
+// result with gopyadapter's remote. Methods called through the handle always run on that worker. The object is freed
+// in python when the handle is released, either explicitly with Release or once the handle is garbage collected.
+//
+// A handle becomes invalid if its worker dies, since the object is lost with it.
+type Handle struct {
+	pool     *Pool
+	worker   *PythonWrapper
+	proc     *process
+	id       uint64
+	typ      string
+	released atomic.Bool
+}
+
+type handleRef struct {
+	ID   uint64 `msgpack:"__gopy_handle__"`
+	Type string `msgpack:"type"`
+}
+
+func CallDefaultHandle(ctx context.Context, pythonFunctionName string, inputObj any, opts ...CallOption) (*Handle, error) {
+	return CallPoolHandle(ctx, DefaultPool, pythonFunctionName, inputObj, opts...)
+}
+
+// CallPoolHandle calls a python function returning a remote object, e.g. `return remote(model)`, and returns a handle
+// to the object.
+func CallPoolHandle(ctx context.Context, p *Pool, pythonFunctionName string, inputObj any, opts ...CallOption) (*Handle, error) {
+	cfg := newCallConfig(ctx, opts)
+	ctx = contextWithProgress(ctx, cfg.progress)
+	worker, err := p.acquireCall(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for free python worker: %w", err)
+	}
+	defer p.release(worker)
+	proc, err := worker.process()
+	if err != nil {
+		return nil, err
+	}
+	ref, err := callProcess[handleRef](ctx, proc, frameCall, pythonFunctionName, inputObj)
+	if err != nil {
+		return nil, err
+	}
+	if ref.ID == 0 {
+		return nil, fmt.Errorf("python function %v did not return a remote object", pythonFunctionName)
+	}
+	h := &Handle{pool: p, worker: worker, proc: proc, id: ref.ID, typ: ref.Type}
+	runtime.SetFinalizer(h, func(h *Handle) {
+		go h.release()
+	})
+	return h, nil
+}
+
+// Type returns the name of the python object's type.
+func (h *Handle) Type() string {
+	return h.typ
+}
+
+// CallMethod calls a method of the python object referred to by the handle on its worker, waiting for a free call slot
+// of that worker. The method is passed inputObj as its single argument, or no arguments if inputObj is nil.
+func CallMethod[T any](ctx context.Context, h *Handle, method string, inputObj any, opts ...CallOption) (T, error) {
+	var result T
+	if h.released.Load() {
+		return result, ErrHandleReleased
+	}
+	cfg := newCallConfig(ctx, opts)
+	ctx = contextWithProgress(ctx, cfg.progress)
+	// a dead worker is accepted too, so the call fails fast instead of waiting for a worker that will never be free
+	worker, err := h.pool.acquireMatching(ctx, cfg.priority, func(w *PythonWrapper) bool {
+		return h.pool.current(w) == h.worker || h.proc.ctx.Err() != nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("waiting for python worker holding object: %w", err)
+	}
+	defer h.pool.release(worker)
+	if worker != h.worker || h.proc.ctx.Err() != nil {
+		return result, fmt.Errorf("python worker holding object died: %w", context.Cause(h.proc.ctx))
+	}
+	result, err = callProcess[T](ctx, h.proc, frameMethod, h.id, method, inputObj)
+	runtime.KeepAlive(h)
+	return result, err
+}
+
+// Release frees the python object. Calling methods through the handle afterwards returns ErrHandleReleased.
+func (h *Handle) Release() {
+	if h.released.Swap(true) {
+		return
+	}
+	runtime.SetFinalizer(h, nil)
+	h.release()
+}
+
+func (h *Handle) release() {
+	if h.proc.ctx.Err() != nil {
+		return
+	}
+	if err := h.proc.write(frameRelease, 0, h.id); err != nil {
+		slog.DebugContext(h.proc.ctx, fmt.Sprintf("releasing python object %v: %v", h.id, err))
+	}
+}
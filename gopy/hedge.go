@@ -0,0 +1,114 @@
+package gopy
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is the number of recent call durations kept per python function
+	latencySamples = 128
+	// minHedgeSamples is the number of samples required before a hedging delay is derived
+	minHedgeSamples = 16
+)
+
+// latencyTracker keeps a rolling window of successful call durations per python function.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples map[string]*latencyWindow
+}
+
+type latencyWindow struct {
+	durations []time.Duration
+	next      int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make(map[string]*latencyWindow)}
+}
+
+func (l *latencyTracker) observe(pythonFunctionName string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	win, ok := l.samples[pythonFunctionName]
+	if !ok {
+		win = &latencyWindow{}
+		l.samples[pythonFunctionName] = win
+	}
+	if len(win.durations) < latencySamples {
+		win.durations = append(win.durations, d)
+		return
+	}
+	win.durations[win.next] = d
+	win.next = (win.next + 1) % latencySamples
+}
+
+// percentile returns the given percentile (0-1) of recent durations, or false if too few calls have been observed.
+func (l *latencyTracker) percentile(pythonFunctionName string, p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	win, ok := l.samples[pythonFunctionName]
+	if !ok || len(win.durations) < minHedgeSamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	sorted := slices.Clone(win.durations)
+	l.mu.Unlock()
+	slices.Sort(sorted)
+	ind := int(p * float64(len(sorted)-1))
+	return sorted[min(max(ind, 0), len(sorted)-1)], true
+}
+
+type hedgeResult[T any] struct {
+	result T
+	err    error
+}
+
+// hedgedCall runs the call on the acquired worker and, if it has not completed within the configured percentile of
+// recent durations for the function, issues the same call on a second idle worker. The first successful result is
+// returned and the other call is cancelled, its worker being freed once python stops running it. No second call is made
+// if no other worker is idle.
+func hedgedCall[T any](ctx context.Context, p *Pool, primary *PythonWrapper, pythonFunctionName string, inputObj any, percentile float64) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[T], 2)
+	run := func(w *PythonWrapper) {
+		defer p.release(w)
+		res, err := callWorker[T](ctx, p, w, pythonFunctionName, inputObj)
+		results <- hedgeResult[T]{res, err}
+	}
+	go run(primary)
+	inFlight := 1
+
+	var hedgeTimer <-chan time.Time
+	if delay, ok := p.latencies.percentile(pythonFunctionName, percentile); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	var firstErr error
+	for {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if w, ok := p.tryAcquire(primary); ok {
+				go run(w)
+				inFlight++
+			}
+		case r := <-results:
+			inFlight--
+			if r.err == nil {
+				return r.result, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if inFlight == 0 {
+				return r.result, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// defaultInputChunk is the number of input stream items sent to python per chunk.
+const defaultInputChunk = 256
+
+func CallDefaultIter[T, I any](ctx context.Context, pythonFunctionName string, input iter.Seq[I], opts ...CallOption) (T, error) {
+	return CallPoolIter[T](ctx, DefaultPool, pythonFunctionName, input, opts...)
+}
+
+// CallPoolIter calls the python function with a lazy iterator as its input. The items of input are only produced as
+// python consumes the iterator, being sent over in chunks (see WithInputChunkSize), so large datasets can be fed to
+// python without materialising them in a single payload. Python may iterate the input with either for or async for.
+// Calls with an input stream are never hedged since the input can't be replayed.
+func CallPoolIter[T, I any](ctx context.Context, p *Pool, pythonFunctionName string, input iter.Seq[I], opts ...CallOption) (T, error) {
+	var result T
+	cfg := newCallConfig(ctx, opts)
+	ctx = contextWithProgress(ctx, cfg.progress)
+	worker, err := p.acquireCall(ctx, cfg)
+	if err != nil {
+		return result, fmt.Errorf("waiting for free python worker: %w", err)
+	}
+	defer p.release(worker)
+	return callIter[T](ctx, worker, pythonFunctionName, input, cfg.inputChunk)
+}
+
+// FromChannel adapts a channel to an input stream for CallPoolIter, the stream ending when the channel is closed.
+func FromChannel[I any](ch <-chan I) iter.Seq[I] {
+	return func(yield func(I) bool) {
+		for item := range ch {
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+func callIter[T, I any](ctx context.Context, w *PythonWrapper, pythonFunctionName string, input iter.Seq[I], chunkSize int) (T, error) {
+	var result T
+	proc, err := w.process()
+	if err != nil {
+		return result, err
+	}
+	pc, err := proc.request(ctx, 1, frameCall, pythonFunctionName, nil, map[string]any{"input_stream": true})
+	if err != nil {
+		return result, err
+	}
+	defer proc.finish(pc)
+	next, stop := iter.Pull(input)
+	defer stop()
+
+	for {
+		f, err := proc.nextWithTimeout(ctx, pc)
+		if err != nil {
+			return result, err
+		}
+		switch f.kind {
+		case framePull:
+			chunk := make([]I, 0, chunkSize)
+			for len(chunk) < chunkSize {
+				item, ok := next()
+				if !ok {
+					break
+				}
+				chunk = append(chunk, item)
+			}
+			if len(chunk) > 0 {
+				err = proc.write(frameInputChunk, pc.id, chunk)
+			} else {
+				err = proc.write(frameInputEnd, pc.id)
+			}
+			if err != nil {
+				return result, err
+			}
+		case frameResult:
+			if err = f.field(0, &result); err != nil {
+				return result, fmt.Errorf("unmarshalling result from child process: %v", err)
+			}
+			return result, nil
+		case frameError:
+			return result, f.pythonError()
+		default:
+			return result, fmt.Errorf("unexpected frame kind %v from child process", f.kind)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package gopy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+	"math"
+	"reflect"
+	"slices"
+)
+
+// ExtNDArray is the base of the extension types NDArray is encoded with. Each element type has its own extension type,
+// ExtNDArray plus the element's dtype code, so arrays decoded into any (e.g. a map[string]any) can be given their
+// element type.
+//
+// The payload of the extension is an 8 byte header (number of dimensions, flags and padding), the size of each
+// dimension as a little endian uint64 and then the elements in row-major (C) order, little endian, or column-major
+// order if the header flags the array as Fortran ordered.
+const ExtNDArray = 64
+
+// ndarrayFortran flags an NDArray extension whose elements are in Fortran order.
+const ndarrayFortran = 0x08
+
+// ndarrayHeaderLen is the length of the fixed part of the NDArray extension payload.
+const ndarrayHeaderLen = 8
+
+// maxNDArrayDims is the most dimensions an NDArray can have, numpy's limit (32 before numpy 2). The header holds the
+// number of dimensions in a single byte.
+const maxNDArrayDims = 64
+
+// Element is the element types an NDArray can hold, one for each numpy dtype that can be transported.
+type Element interface {
+	bool | int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 | Float16 | float32 | float64 | complex64 |
+		complex128
+}
+
+// dtype codes identifying element types in the NDArray extension format.
+const (
+	dtypeBool       = 1
+	dtypeInt8       = 2
+	dtypeUint8      = 3
+	dtypeInt16      = 4
+	dtypeUint16     = 5
+	dtypeInt32      = 6
+	dtypeUint32     = 7
+	dtypeInt64      = 8
+	dtypeUint64     = 9
+	dtypeFloat16    = 10
+	dtypeFloat32    = 11
+	dtypeFloat64    = 12
+	dtypeComplex64  = 13
+	dtypeComplex128 = 14
+)
+
+// dtypeOf returns the dtype code and element size in bytes of T.
+func dtypeOf[T Element]() (int, int) {
+	var zero T
+	switch any(zero).(type) {
+	case bool:
+		return dtypeBool, 1
+	case int8:
+		return dtypeInt8, 1
+	case uint8:
+		return dtypeUint8, 1
+	case int16:
+		return dtypeInt16, 2
+	case uint16:
+		return dtypeUint16, 2
+	case int32:
+		return dtypeInt32, 4
+	case uint32:
+		return dtypeUint32, 4
+	case int64:
+		return dtypeInt64, 8
+	case uint64:
+		return dtypeUint64, 8
+	case Float16:
+		return dtypeFloat16, 2
+	case float32:
+		return dtypeFloat32, 4
+	case float64:
+		return dtypeFloat64, 8
+	case complex64:
+		return dtypeComplex64, 8
+	default:
+		return dtypeComplex128, 16
+	}
+}
+
+// Order is the layout of the elements of an NDArray in memory.
+type Order byte
+
+const (
+	// OrderC is row-major order, the last index varying fastest, numpy's default.
+	OrderC Order = iota
+	// OrderF is column-major (Fortran) order, the first index varying fastest, e.g. the transpose of a C order array.
+	OrderF
+)
+
+// NDArray is an n-dimensional array of any rank, including 0 (a scalar), stored as a flat slice of elements in
+// row-major (C) order, or column-major (Fortran) order if Order is OrderF, plus its shape. It is transported to and
+// from python as a numpy array of the same shape, dtype and order. The zero value, with neither elements nor shape, is
+// transported as None.
+//
+// Arrays returned by Index, Slice and Reshape share their elements with the original, unless it is in Fortran order, in
+// which case its elements are first copied in C order.
+type NDArray[T Element] struct {
+	Data  []T
+	Shape []int
+	Order Order
+}
+
+// NewNDArray returns a zeroed array of the given shape.
+func NewNDArray[T Element](shape ...int) NDArray[T] {
+	return NDArray[T]{Data: make([]T, shapeSize(shape)), Shape: shape}
+}
+
+// NDArrayFrom returns an array of the given shape backed by data, which must have exactly as many elements as the
+// shape.
+func NDArrayFrom[T Element](data []T, shape ...int) (NDArray[T], error) {
+	a := NDArray[T]{Data: data, Shape: shape}
+	if err := a.validate(); err != nil {
+		return NDArray[T]{}, err
+	}
+	return a, nil
+}
+
+func shapeSize(shape []int) int {
+	n := 1
+	for _, d := range shape {
+		n *= d
+	}
+	return n
+}
+
+// arrayBytes checks an array shape and returns the number of elements of an array of that shape with elements of the
+// given size in bytes, and the size of the elements in bytes.
+func arrayBytes(shape []int, size int) (int, int, error) {
+	if len(shape) > maxNDArrayDims {
+		return 0, 0, fmt.Errorf("invalid %v-d array: numpy arrays have at most %v dimensions", len(shape), maxNDArrayDims)
+	}
+	n := 1
+	for _, d := range shape {
+		if d < 0 {
+			return 0, 0, fmt.Errorf("invalid array shape %v: negative dimension", shape)
+		}
+		if d > 0 && n > math.MaxInt/d {
+			return 0, 0, fmt.Errorf("invalid array shape %v: too many elements", shape)
+		}
+		n *= d
+	}
+	if n > math.MaxInt/size {
+		return 0, 0, fmt.Errorf("invalid array shape %v: too many elements", shape)
+	}
+	return n, n * size, nil
+}
+
+func (a NDArray[T]) validate() error {
+	if a.Order != OrderC && a.Order != OrderF {
+		return fmt.Errorf("invalid array order %v", a.Order)
+	}
+	_, size := dtypeOf[T]()
+	if n, _, err := arrayBytes(a.Shape, size); err != nil {
+		return err
+	} else if n != len(a.Data) {
+		return fmt.Errorf("array of shape %v needs %v elements but has %v", a.Shape, n, len(a.Data))
+	}
+	return nil
+}
+
+// Ndim returns the number of dimensions.
+func (a NDArray[T]) Ndim() int {
+	return len(a.Shape)
+}
+
+// Size returns the number of elements.
+func (a NDArray[T]) Size() int {
+	return shapeSize(a.Shape)
+}
+
+// Strides returns the number of elements to step in Data to move one position along each dimension.
+func (a NDArray[T]) Strides() []int {
+	strides := make([]int, len(a.Shape))
+	step := 1
+	if a.Order == OrderF {
+		for i, d := range a.Shape {
+			strides[i] = step
+			step *= d
+		}
+		return strides
+	}
+	for i := len(a.Shape) - 1; i >= 0; i-- {
+		strides[i] = step
+		step *= a.Shape[i]
+	}
+	return strides
+}
+
+// AsOrder returns the array with its elements in the given order: a itself if they already are, or a copy otherwise.
+func (a NDArray[T]) AsOrder(order Order) NDArray[T] {
+	if a.Order == order {
+		return a
+	}
+	b := NDArray[T]{Data: a.Data, Shape: a.Shape, Order: order}
+	if len(a.Shape) < 2 {
+		// both orders are the same
+		return b
+	}
+	b.Data = make([]T, len(a.Data))
+	from, to := a.Strides(), b.Strides()
+	idx := make([]int, len(a.Shape))
+	src, dst := 0, 0
+	for range a.Data {
+		b.Data[dst] = a.Data[src]
+		for k := len(idx) - 1; k >= 0; k-- {
+			idx[k]++
+			src += from[k]
+			dst += to[k]
+			if idx[k] < a.Shape[k] {
+				break
+			}
+			src -= from[k] * a.Shape[k]
+			dst -= to[k] * a.Shape[k]
+			idx[k] = 0
+		}
+	}
+	return b
+}
+
+// Transpose returns the array with its dimensions reversed, like a.T in numpy, sharing its elements: the transpose of
+// a C order array is in Fortran order and vice versa.
+func (a NDArray[T]) Transpose() NDArray[T] {
+	shape := slices.Clone(a.Shape)
+	slices.Reverse(shape)
+	order := OrderF
+	if a.Order == OrderF {
+		order = OrderC
+	}
+	return NDArray[T]{Data: a.Data, Shape: shape, Order: order}
+}
+
+// Offset returns the position in Data of the element at the given index, which must have one value per dimension. It
+// panics if the index is out of range.
+func (a NDArray[T]) Offset(idx ...int) int {
+	if len(idx) != len(a.Shape) {
+		panic(fmt.Sprintf("gopy: index %v of %v dimensions for array of shape %v", idx, len(idx), a.Shape))
+	}
+	offset := 0
+	for i, step := range a.Strides() {
+		if idx[i] < 0 || idx[i] >= a.Shape[i] {
+			panic(fmt.Sprintf("gopy: index %v out of range for array of shape %v", idx, a.Shape))
+		}
+		offset += idx[i] * step
+	}
+	return offset
+}
+
+// At returns the element at the given index.
+func (a NDArray[T]) At(idx ...int) T {
+	return a.Data[a.Offset(idx...)]
+}
+
+// Set sets the element at the given index.
+func (a NDArray[T]) Set(v T, idx ...int) {
+	a.Data[a.Offset(idx...)] = v
+}
+
+// Reshape returns the array with a new shape of the same size. One dimension may be -1, in which case it is inferred
+// from the size.
+func (a NDArray[T]) Reshape(shape ...int) (NDArray[T], error) {
+	a = a.AsOrder(OrderC)
+	shape = append([]int(nil), shape...)
+	infer := -1
+	known := 1
+	for i, d := range shape {
+		switch {
+		case d == -1 && infer == -1:
+			infer = i
+		case d < 0:
+			return NDArray[T]{}, fmt.Errorf("invalid shape %v for reshape", shape)
+		default:
+			known *= d
+		}
+	}
+	if infer >= 0 && known > 0 {
+		shape[infer] = len(a.Data) / known
+	}
+	if n, _, err := arrayBytes(shape, 1); err != nil || n != len(a.Data) {
+		return NDArray[T]{}, fmt.Errorf("cannot reshape array of size %v into shape %v", len(a.Data), shape)
+	}
+	return NDArray[T]{Data: a.Data, Shape: shape}, nil
+}
+
+// Index returns the sub-array at position i of the first dimension, e.g. a row of a 2-D array, with one dimension
+// less. It panics if the array is 0-d or i is out of range.
+func (a NDArray[T]) Index(i int) NDArray[T] {
+	if len(a.Shape) == 0 || i < 0 || i >= a.Shape[0] {
+		panic(fmt.Sprintf("gopy: index %v out of range for array of shape %v", i, a.Shape))
+	}
+	a = a.AsOrder(OrderC)
+	n := shapeSize(a.Shape[1:])
+	return NDArray[T]{Data: a.Data[i*n : (i+1)*n], Shape: a.Shape[1:]}
+}
+
+// Slice returns the positions start to end (exclusive) of the first dimension, like a[start:end] in numpy. It panics
+// if the array is 0-d or the range is invalid.
+func (a NDArray[T]) Slice(start, end int) NDArray[T] {
+	if len(a.Shape) == 0 || start < 0 || end < start || end > a.Shape[0] {
+		panic(fmt.Sprintf("gopy: slice [%v:%v] out of range for array of shape %v", start, end, a.Shape))
+	}
+	a = a.AsOrder(OrderC)
+	n := shapeSize(a.Shape[1:])
+	shape := append([]int{end - start}, a.Shape[1:]...)
+	return NDArray[T]{Data: a.Data[start*n : end*n], Shape: shape}
+}
+
+// littleEndianBytes returns the elements as little endian bytes, the memory of the array itself on little endian hosts.
+func (a NDArray[T]) littleEndianBytes() []byte {
+	if nativeLittleEndian {
+		return elementBytes(a.Data)
+	}
+	_, size := dtypeOf[T]()
+	data := make([]byte, len(a.Data)*size)
+	elementsToBytes(a.Data, data)
+	return data
+}
+
+func (a NDArray[T]) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if a.Data == nil && a.Shape == nil {
+		return enc.EncodeNil()
+	}
+	if err := a.validate(); err != nil {
+		return err
+	}
+	code, _ := dtypeOf[T]()
+	var flags byte
+	if a.Order == OrderF && len(a.Shape) > 1 {
+		flags |= ndarrayFortran
+	}
+	header := encodeNDArrayHeader(a.Shape, flags)
+	// written by reference when encoding a frame, see frameBuffer
+	data := a.littleEndianBytes()
+	if b, ok := enc.Writer().(*frameBuffer); ok {
+		path, shared, err := b.share(data)
+		if err != nil {
+			return err
+		}
+		if shared {
+			header[1] |= ndarrayShared
+			data = []byte(path)
+		}
+	}
+	if err := enc.EncodeExtHeader(int8(ExtNDArray+code), len(header)+len(data)); err != nil {
+		return err
+	}
+	if _, err := enc.Writer().Write(header); err != nil {
+		return err
+	}
+	_, err := enc.Writer().Write(data)
+	return err
+}
+
+// DecodeMsgpack decodes an array, or nil (python's None) into the zero value.
+func (a *NDArray[T]) DecodeMsgpack(dec *msgpack.Decoder) error {
+	c, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+	if c == msgpcode.Nil {
+		*a = NDArray[T]{}
+		return dec.DecodeNil()
+	}
+	extID, extLen, err := dec.DecodeExtHeader()
+	if err != nil {
+		return err
+	}
+	code, _ := dtypeOf[T]()
+	if int(extID) != ExtNDArray+code {
+		return fmt.Errorf("msgpack: got ext type=%v, wanted %v", extID, ExtNDArray+code)
+	}
+	return a.decodeExt(dec, extLen)
+}
+
+func init() {
+	registerNDArray[bool]()
+	registerNDArray[int8]()
+	registerNDArray[uint8]()
+	registerNDArray[int16]()
+	registerNDArray[uint16]()
+	registerNDArray[int32]()
+	registerNDArray[uint32]()
+	registerNDArray[int64]()
+	registerNDArray[uint64]()
+	registerNDArray[Float16]()
+	registerNDArray[float32]()
+	registerNDArray[float64]()
+	registerNDArray[complex64]()
+	registerNDArray[complex128]()
+}
+
+// registerNDArray registers the decoder of the extension type of NDArray[T], so arrays decoded into any or a
+// map[string]any become an NDArray of their element type. Only a decoder is registered, msgpack.RegisterExt would wrap
+// the encoded array in a second extension header.
+func registerNDArray[T Element]() {
+	code, _ := dtypeOf[T]()
+	msgpack.RegisterExtDecoder(int8(ExtNDArray+code), NDArray[T]{}, func(dec *msgpack.Decoder, v reflect.Value, extLen int) error {
+		return v.Addr().Interface().(*NDArray[T]).decodeExt(dec, extLen)
+	})
+	// the extension decoder also becomes the decoder of NDArray[T] values, which panics on nil since msgpack treats a
+	// nil into a struct like a nil pointer, so values go through DecodeMsgpack instead
+	msgpack.Register(NDArray[T]{}, nil, func(dec *msgpack.Decoder, v reflect.Value) error {
+		if !v.CanAddr() {
+			return fmt.Errorf("msgpack: decoding into unaddressable %v", v.Type())
+		}
+		return v.Addr().Interface().(*NDArray[T]).DecodeMsgpack(dec)
+	})
+}
+
+// decodeExt decodes the payload of the NDArray extension type, of length extLen. The elements are read straight into
+// the array's memory on little endian hosts, from the pipe, the shared memory segment or the file holding them.
+func (a *NDArray[T]) decodeExt(dec *msgpack.Decoder, extLen int) error {
+	code, size := dtypeOf[T]()
+	flags, shape, dataLen, err := decodeNDArrayHeader(dec, extLen)
+	if err != nil {
+		return err
+	}
+	n, nbytes, err := arrayBytes(shape, size)
+	if err != nil {
+		return err
+	}
+	// the size of the elements is checked before allocating them, so a malformed shape can't exhaust memory
+	read := dec.ReadFull
+	if flags&ndarrayShared != 0 {
+		path := make([]byte, dataLen)
+		if err := dec.ReadFull(path); err != nil {
+			return err
+		}
+		if err := checkSegment(string(path), nbytes); err != nil {
+			return err
+		}
+		read = func(dest []byte) error {
+			return readSegment(string(path), dest)
+		}
+	} else if flags&ndarrayFile != 0 {
+		f := FileArray[T]{Shape: shape}
+		if err := f.decodeRef(dec, flags, dataLen); err != nil {
+			return err
+		}
+		file, _, err := f.open()
+		if err != nil {
+			return err
+		}
+		file.Close()
+		read = f.readAll
+	} else if nbytes != dataLen {
+		return fmt.Errorf("invalid array of shape %v: expected %v bytes of elements but got %v", shape, nbytes, dataLen)
+	}
+	a.Shape = shape
+	a.Data = make([]T, n)
+	a.Order = OrderC
+	if flags&ndarrayFortran != 0 {
+		a.Order = OrderF
+	}
+	if nativeLittleEndian && code != dtypeBool {
+		// bools are converted so any non-zero byte is true
+		return read(elementBytes(a.Data))
+	}
+	data := make([]byte, nbytes)
+	if err := read(data); err != nil {
+		return err
+	}
+	bytesToElements(data, a.Data)
+	return nil
+}
+
+// decodeNDArrayHeader decodes the header and shape of an NDArray extension of length extLen, returning the flags, the
+// shape and the length of the rest of the payload.
+func decodeNDArrayHeader(dec *msgpack.Decoder, extLen int) (byte, []int, int, error) {
+	if extLen < ndarrayHeaderLen {
+		return 0, nil, 0, fmt.Errorf("invalid array: %v bytes is too short for its header", extLen)
+	}
+	header := make([]byte, ndarrayHeaderLen)
+	if err := dec.ReadFull(header); err != nil {
+		return 0, nil, 0, err
+	}
+	ndim := int(header[0])
+	if extLen < ndarrayHeaderLen+8*ndim {
+		return 0, nil, 0, fmt.Errorf("invalid %v-d array: %v bytes is too short for its shape", ndim, extLen)
+	}
+	dims := make([]byte, 8*ndim)
+	if err := dec.ReadFull(dims); err != nil {
+		return 0, nil, 0, err
+	}
+	shape := make([]int, ndim)
+	for i := range shape {
+		d := binary.LittleEndian.Uint64(dims[8*i:])
+		if d > math.MaxInt {
+			return 0, nil, 0, fmt.Errorf("invalid %v-d array: dimension %v of size %v is too large", ndim, i, d)
+		}
+		shape[i] = int(d)
+	}
+	return header[1], shape, extLen - ndarrayHeaderLen - len(dims), nil
+}
+
+// encodeNDArrayHeader returns the header and shape of an NDArray extension, the shape having been checked with
+// arrayBytes.
+func encodeNDArrayHeader(shape []int, flags byte) []byte {
+	header := make([]byte, ndarrayHeaderLen+8*len(shape))
+	header[0] = byte(len(shape))
+	header[1] = flags
+	for i, d := range shape {
+		binary.LittleEndian.PutUint64(header[ndarrayHeaderLen+8*i:], uint64(d))
+	}
+	return header
+}
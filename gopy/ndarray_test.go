@@ -0,0 +1,235 @@
+package gopy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vmihailenco/msgpack/v5"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNDArray(t *testing.T) {
+	a, err := NDArrayFrom([]int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, 2, 3, 2)
+	if err != nil {
+		t.Fatalf("NDArrayFrom() error = %v", err)
+	}
+	if got := a.At(1, 2, 0); got != 10 {
+		t.Errorf("At(1, 2, 0) = %v, want 10", got)
+	}
+	a.Set(-1, 0, 1, 1)
+	if a.Data[3] != -1 {
+		t.Errorf("Set(-1, 0, 1, 1) set Data = %v", a.Data)
+	}
+	if got := a.Index(1); !reflect.DeepEqual(got.Shape, []int{3, 2}) || got.At(0, 1) != 7 {
+		t.Errorf("Index(1) = %+v", got)
+	}
+	if got := a.Slice(1, 2); !reflect.DeepEqual(got.Shape, []int{1, 3, 2}) || got.At(0, 0, 0) != 6 {
+		t.Errorf("Slice(1, 2) = %+v", got)
+	}
+	r, err := a.Reshape(4, -1)
+	if err != nil || !reflect.DeepEqual(r.Shape, []int{4, 3}) || r.At(3, 2) != 11 {
+		t.Errorf("Reshape(4, -1) = %+v, %v", r, err)
+	}
+	if _, err := a.Reshape(5, -1); err == nil {
+		t.Errorf("Reshape(5, -1) error = nil, want error")
+	}
+	if _, err := NDArrayFrom([]int32{1, 2, 3}, 2, 2); err == nil {
+		t.Errorf("NDArrayFrom() with 3 elements for shape [2 2] error = nil, want error")
+	}
+
+	for _, in := range []any{a, NewNDArray[uint16](0, 3), NDArray[float32]{Data: []float32{2.5}, Shape: []int{}}} {
+		data, err := msgpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal(%+v) error = %v", in, err)
+		}
+		out := reflect.New(reflect.TypeOf(in))
+		if err := msgpack.Unmarshal(data, out.Interface()); err != nil {
+			t.Fatalf("Unmarshal(%+v) error = %v", in, err)
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Errorf("round trip of %+v = %+v", in, out.Elem().Interface())
+		}
+	}
+
+	// the fixed rank array types are encoded as NDArray
+	data, err := msgpack.Marshal(Float32_2DArray{{1, 2, 3}, {4, 5, 6}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var f NDArray[float32]
+	if err := msgpack.Unmarshal(data, &f); err != nil || !reflect.DeepEqual(f.Shape, []int{2, 3}) || f.At(1, 0) != 4 {
+		t.Errorf("Unmarshal(Float32_2DArray) = %+v, %v", f, err)
+	}
+}
+
+func TestNDArrayOrder(t *testing.T) {
+	a := NDArray[int32]{Data: []int32{1, 2, 3, 4, 5, 6}, Shape: []int{2, 3}}
+	f := a.AsOrder(OrderF)
+	if !reflect.DeepEqual(f.Data, []int32{1, 4, 2, 5, 3, 6}) || f.At(0, 1) != 2 || f.At(1, 2) != 6 {
+		t.Errorf("AsOrder(OrderF) = %+v", f)
+	}
+	if !reflect.DeepEqual(f.Strides(), []int{1, 2}) {
+		t.Errorf("Strides() = %v, want [1 2]", f.Strides())
+	}
+	if got := f.AsOrder(OrderC); !reflect.DeepEqual(got, a) {
+		t.Errorf("AsOrder(OrderC) = %+v, want %+v", got, a)
+	}
+	if got := f.Index(1); !reflect.DeepEqual(got.Data, []int32{4, 5, 6}) {
+		t.Errorf("Index(1) of Fortran order array = %+v", got)
+	}
+
+	// the transpose shares the elements in the opposite order
+	tr := a.Transpose()
+	if tr.Order != OrderF || !reflect.DeepEqual(tr.Shape, []int{3, 2}) || tr.At(2, 1) != 6 || tr.At(1, 0) != 2 {
+		t.Errorf("Transpose() = %+v", tr)
+	}
+	if got := tr.Transpose(); !reflect.DeepEqual(got, a) {
+		t.Errorf("Transpose().Transpose() = %+v, want %+v", got, a)
+	}
+
+	b := NewNDArray[float64](2, 3, 4)
+	for i := range b.Data {
+		b.Data[i] = float64(i)
+	}
+	if got := b.AsOrder(OrderF); got.At(1, 2, 3) != 23 || got.At(0, 1, 2) != 6 {
+		t.Errorf("AsOrder(OrderF) of 3-d array = %+v", got)
+	}
+
+	data, err := msgpack.Marshal(f)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var out NDArray[int32]
+	if err := msgpack.Unmarshal(data, &out); err != nil || !reflect.DeepEqual(out, f) {
+		t.Errorf("round trip of %+v = %+v, %v", f, out, err)
+	}
+	// fixed rank types are normalised to C order
+	var nested Int32_2DArray
+	if err := msgpack.Unmarshal(data, &nested); err != nil || !reflect.DeepEqual(nested, Int32_2DArray{{1, 2, 3}, {4, 5, 6}}) {
+		t.Errorf("Unmarshal(Int32_2DArray) = %v, %v", nested, err)
+	}
+}
+
+func TestNDArrayMalformed(t *testing.T) {
+	// payload builds an NDArray[float64] extension from a header, shape and data
+	payload := func(ndim, flags byte, shape []uint64, data []byte) []byte {
+		p := []byte{ndim, flags, 0, 0, 0, 0, 0, 0}
+		for _, d := range shape {
+			p = binary.LittleEndian.AppendUint64(p, d)
+		}
+		p = append(p, data...)
+		var b bytes.Buffer
+		enc := msgpack.NewEncoder(&b)
+		if err := enc.EncodeExtHeader(int8(ExtNDArray+dtypeFloat64), len(p)); err != nil {
+			t.Fatal(err)
+		}
+		b.Write(p)
+		return b.Bytes()
+	}
+	for name, data := range map[string][]byte{
+		"short header":        payload(0, 0, nil, nil)[:5],
+		"missing shape":       payload(2, 0, []uint64{1}, nil),
+		"missing elements":    payload(1, 0, []uint64{2}, make([]byte, 8)),
+		"extra elements":      payload(1, 0, []uint64{1}, make([]byte, 16)),
+		"negative dimension":  payload(1, 0, []uint64{1 << 63}, nil),
+		"overflowing shape":   payload(2, 0, []uint64{1 << 32, 1 << 32}, nil),
+		"huge shape":          payload(1, 0, []uint64{1 << 60}, make([]byte, 8)),
+		"invalid segment":     payload(1, ndarrayShared, []uint64{1 << 40}, []byte("/etc/passwd")),
+		"missing segment":     payload(1, ndarrayShared, []uint64{1}, []byte(filepath.Join(sharedMemoryDir, "gopy-missing"))),
+		"short file ref":      payload(1, ndarrayFile, []uint64{1}, []byte{1, 2}),
+		"missing file":        payload(1, ndarrayFile, []uint64{1}, append(make([]byte, 8), "/nonexistent"...)),
+		"file too short":      payload(1, ndarrayFile, []uint64{1 << 40}, append(make([]byte, 8), "/dev/null"...)),
+		"truncated extension": payload(1, 0, []uint64{2}, make([]byte, 16))[:20],
+	} {
+		var a NDArray[float64]
+		if err := msgpack.Unmarshal(data, &a); err == nil {
+			t.Errorf("Unmarshal() of array with %v error = nil, want error", name)
+		}
+	}
+
+	if _, err := NDArrayFrom(make([]int8, 0), 1<<62, 1<<62, 0); err == nil {
+		t.Errorf("NDArrayFrom() with overflowing shape error = nil, want error")
+	}
+
+	// the number of dimensions is encoded in a single byte
+	shape := make([]int, 256)
+	for i := range shape {
+		shape[i] = 1
+	}
+	if _, err := msgpack.Marshal(NDArray[float64]{Data: []float64{1}, Shape: shape}); err == nil {
+		t.Errorf("Marshal() of 256-d array error = nil, want error")
+	}
+	if _, err := msgpack.Marshal(FileArray[float64]{Path: "array.bin", Shape: shape}); err == nil {
+		t.Errorf("Marshal() of 256-d file array error = nil, want error")
+	}
+	if _, err := msgpack.Marshal(NDArray[float64]{Data: []float64{1}, Shape: shape[:maxNDArrayDims]}); err != nil {
+		t.Errorf("Marshal() of %v-d array error = %v", maxNDArrayDims, err)
+	}
+}
+
+func TestNDArrayNil(t *testing.T) {
+	// python's None, e.g. a function returning None or an unset field
+	null, err := msgpack.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NDArray[float64]{Data: []float64{1}, Shape: []int{1}}
+	if err := msgpack.Unmarshal(null, &a); err != nil || a.Data != nil || a.Shape != nil {
+		t.Errorf("Unmarshal() of nil = %+v, %v, want zero array", a, err)
+	}
+
+	type result struct {
+		N NDArray[float64]  `msgpack:"n"`
+		M Float32_2DArray   `msgpack:"m"`
+		P *NDArray[float64] `msgpack:"p"`
+	}
+	fields, err := msgpack.Marshal(map[string]any{"n": nil, "m": nil, "p": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r result
+	if err := msgpack.Unmarshal(fields, &r); err != nil || r.N.Shape != nil || r.M != nil || r.P != nil {
+		t.Errorf("Unmarshal() of nil fields = %+v, %v, want zero values", r, err)
+	}
+
+	rows, err := msgpack.Marshal([]any{NDArray[float64]{Data: []float64{1, 2}, Shape: []int{2}}, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ragged Ragged[float64]
+	if err := msgpack.Unmarshal(rows, &ragged); err != nil || len(ragged) != 2 || len(ragged[0]) != 2 || ragged[1] != nil {
+		t.Errorf("Unmarshal() of ragged array with nil row = %v, %v, want [[1 2] []]", ragged, err)
+	}
+}
+
+func TestNDArrayZero(t *testing.T) {
+	type withArrays struct {
+		N NDArray[float64] `msgpack:"n"`
+		O NDArray[float64] `msgpack:"o,omitempty"`
+	}
+	data, err := msgpack.Marshal(withArrays{})
+	if err != nil {
+		t.Fatalf("Marshal() of unset arrays error = %v", err)
+	}
+	var fields map[string]any
+	if err := msgpack.Unmarshal(data, &fields); err != nil || fields["n"] != nil {
+		t.Errorf("Marshal() of unset array = %v, %v, want nil", fields, err)
+	}
+	out := withArrays{N: NDArray[float64]{Data: []float64{1}, Shape: []int{1}}}
+	if err := msgpack.Unmarshal(data, &out); err != nil || !reflect.DeepEqual(out, withArrays{}) {
+		t.Errorf("Unmarshal() of unset arrays = %+v, %v, want zero values", out, err)
+	}
+
+	// a 0-d array has an element, so it isn't the zero value
+	scalar := NewNDArray[float64]()
+	scalar.Data[0] = 2
+	data, err = msgpack.Marshal(scalar)
+	if err != nil {
+		t.Fatalf("Marshal() of 0-d array error = %v", err)
+	}
+	var got NDArray[float64]
+	if err := msgpack.Unmarshal(data, &got); err != nil || len(got.Data) != 1 || got.Data[0] != 2 || len(got.Shape) != 0 {
+		t.Errorf("Unmarshal() of 0-d array = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,198 @@
+package gopy
+
+import (
+	"context"
+	"time"
+)
+
+type poolConfig struct {
+	priorityAging         time.Duration
+	shedTarget            time.Duration
+	standby               int
+	forkServer            bool
+	preload               []string
+	concurrency           int
+	widenFloat16          bool
+	preserveArrayOrder    bool
+	sharedMemoryThreshold int
+}
+
+func defaultPoolConfig() poolConfig {
+	return poolConfig{
+		priorityAging: 5 * time.Second,
+		concurrency:   1,
+	}
+}
+
+// PoolOption configures a Pool created by NewPool or InitDefaultPool.
+type PoolOption func(*poolConfig)
+
+// WithPriorityAging sets how long a queued call must wait before its priority is raised by one level. This protects
+// low priority calls from starvation when higher priority calls keep arriving. Zero disables aging so priorities are
+// served strictly. The default is 5 seconds.
+func WithPriorityAging(d time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.priorityAging = d
+	}
+}
+
+// WithLoadShedding enables adaptive load shedding. While calls are observed to wait in the queue for longer than
+// target, new calls that cannot be served immediately fail fast with ErrOverloaded instead of queueing. Normal and high
+// priority calls tolerate two and three times the target respectively before being shed. Disabled by default.
+func WithLoadShedding(target time.Duration) PoolOption {
+	return func(c *poolConfig) {
+		c.shedTarget = target
+	}
+}
+
+// WithStandbyWorkers keeps n pre-started python processes in reserve. When an active worker is found dead it is
+// replaced by a standby immediately instead of paying the python startup cost on the next call, and a new standby is
+// started in the background. Failed standby starts are retried with exponential backoff.
+func WithStandbyWorkers(n int) PoolOption {
+	return func(c *poolConfig) {
+		c.standby = n
+	}
+}
+
+// WithForkServer starts workers by forking them from a template python process instead of executing a fresh
+// interpreter each time. The template imports the given modules once (e.g. "numpy", "scipy" or the entry script's own
+// modules), so forked workers start without re-importing them and share their memory copy-on-write. Requires a
+// platform supporting fork (Linux, macOS).
+func WithForkServer(preloadModules ...string) PoolOption {
+	return func(c *poolConfig) {
+		c.forkServer = true
+		c.preload = preloadModules
+	}
+}
+
+// WithWorkerConcurrency sets how many calls may be in flight on each worker at once. Python runs synchronous functions
+// on a thread pool of this size, which suits I/O bound functions and free-threaded python, while coroutine (async def)
+// functions all run on the worker's event loop, so a high limit is cheap for async functions. The default is 1.
+func WithWorkerConcurrency(n int) PoolOption {
+	return func(c *poolConfig) {
+		c.concurrency = max(n, 1)
+	}
+}
+
+// WithFloat16AsFloat32 makes workers widen numpy float16 arrays to float32 before sending them, so they decode into
+// float32 element types (e.g. NDArray[float32] or Float32_Array) instead of Float16.
+func WithFloat16AsFloat32() PoolOption {
+	return func(c *poolConfig) {
+		c.widenFloat16 = true
+	}
+}
+
+// WithArrayOrderPreserved makes workers send numpy arrays laid out in Fortran order, e.g. transposes, in that order,
+// so they decode into an NDArray with Order OrderF without reordering their elements. By default arrays are normalised
+// to C order. Other non-contiguous arrays, e.g. strided slices, are copied in whichever order is closest to their
+// layout.
+func WithArrayOrderPreserved() PoolOption {
+	return func(c *poolConfig) {
+		c.preserveArrayOrder = true
+	}
+}
+
+// WithSharedMemory passes arrays of at least threshold bytes between go and the workers through shared memory segments
+// (files in /dev/shm, or the temporary directory where it doesn't exist) instead of copying them through the pipe. Only
+// the path of the segment is sent, python maps it with np.frombuffer over mmap, and the receiving side removes the
+// segment once it has read or mapped it. Arrays received by python this way are read-only, like arrays received through
+// the pipe. Segments of a worker that dies are removed when its death is noticed.
+func WithSharedMemory(threshold int) PoolOption {
+	return func(c *poolConfig) {
+		c.sharedMemoryThreshold = max(threshold, 1)
+	}
+}
+
+type callConfig struct {
+	priority        Priority
+	hasPriority     bool
+	hedgePercentile float64
+	streamBuffer    int
+	inputChunk      int
+	progress        func(context.Context, Progress)
+	affinity        string
+	hasAffinity     bool
+}
+
+func newCallConfig(ctx context.Context, opts []CallOption) callConfig {
+	c := callConfig{streamBuffer: defaultStreamBuffer, inputChunk: defaultInputChunk}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if !c.hasPriority {
+		c.priority = PriorityFromContext(ctx)
+	}
+	return c
+}
+
+// CallOption configures a single call made through a Pool.
+type CallOption func(*callConfig)
+
+// WithPriority sets the priority the call is queued with, overriding any priority carried by the context.
+func WithPriority(priority Priority) CallOption {
+	return func(c *callConfig) {
+		c.priority = priority
+		c.hasPriority = true
+	}
+}
+
+// WithHedging makes the call hedged: if it has not completed after the given percentile (0-1, e.g. 0.95) of recent
+// durations of the same python function, the call is also sent to a second idle worker and whichever finishes first
+// is returned and the slower call is cancelled. Only use this for idempotent, low latency functions. Calls are not
+// hedged until enough durations of the function have been observed, nor when routed to a worker with WithAffinity.
+func WithHedging(percentile float64) CallOption {
+	return func(c *callConfig) {
+		c.hedgePercentile = percentile
+	}
+}
+
+// WithStreamBuffer sets how many items a python generator streamed with StreamPool may produce ahead of the go
+// consumer. The default is 16.
+func WithStreamBuffer(n int) CallOption {
+	return func(c *callConfig) {
+		c.streamBuffer = max(n, 1)
+	}
+}
+
+// WithInputChunkSize sets how many items of an input stream passed with CallPoolIter are sent to python at a time.
+// The default is 256.
+func WithInputChunkSize(n int) CallOption {
+	return func(c *callConfig) {
+		c.inputChunk = max(n, 1)
+	}
+}
+
+// WithProgress calls fn with each progress report python sends with report_progress during the call. Reports are
+// queued and passed to fn in order on a separate goroutine, so a slow fn doesn't hold up python or other calls, and
+// all are delivered before the call returns.
+func WithProgress(fn func(Progress)) CallOption {
+	return func(c *callConfig) {
+		c.progress = func(ctx context.Context, p Progress) { fn(p) }
+	}
+}
+
+// WithProgressChan sends each progress report python sends with report_progress during the call to ch, in order and
+// before the call returns. Reports are queued while ch is full, and the call only returns once they have all been
+// received or the call's context is done, so ch should be buffered or drained concurrently.
+func WithProgressChan(ch chan<- Progress) CallOption {
+	return func(c *callConfig) {
+		c.progress = func(ctx context.Context, p Progress) {
+			select {
+			case ch <- p:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// WithAffinity routes the call to the worker owning key by consistent hashing, so calls with the same key (e.g. a
+// tenant ID or model name) run on the same worker and find its per-key caches warm. The call waits for that worker
+// even while others are idle. While a worker is dead its keys are routed to the next live workers on the ring, and
+// return to it once it is restarted or replaced by a standby, the other keys staying where they are. Affinity calls
+// are not hedged, the hedge having to run on another worker.
+func WithAffinity(key string) CallOption {
+	return func(c *callConfig) {
+		c.affinity = key
+		c.hasAffinity = true
+	}
+}
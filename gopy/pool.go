@@ -4,11 +4,9 @@ import (
 	"bufio"
 	"context"
 	"embed"
-	"errors"
 	"fmt"
 	"github.com/jptrs93/goutil/cmdu"
 	"github.com/jptrs93/goutil/contextu"
-	"github.com/vmihailenco/msgpack/v5"
 	"io"
 	"io/fs"
 	"log/slog"
@@ -16,18 +14,24 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 var DefaultPool *Pool
 
-func InitDefaultPool(scripts embed.FS, executablePath, entryScript string, n int) {
+// callTimeout is how long python may take to respond before the worker is considered hung and is killed. Time python
+// spends waiting on go handlers doesn't count.
+var callTimeout = 10 * time.Second
+
+func InitDefaultPool(scripts embed.FS, executablePath, entryScript string, n int, opts ...PoolOption) {
 	if DefaultPool != nil {
 		panic("InitDefaultPool called more than once")
 	}
-	DefaultPool = NewPool(context.Background(), scripts, executablePath, entryScript, n)
+	DefaultPool = NewPool(context.Background(), scripts, executablePath, entryScript, n, opts...)
 
 	// Set up signal handling
 	signalChan := make(chan os.Signal, 1)
@@ -61,13 +65,34 @@ type Pool struct {
 	executablePath string
 	entryScript    string
 	workers        []*PythonWrapper
-	nextInd        int
-	nextIndMu      sync.Mutex
+	standby        []*PythonWrapper
+	starting       int
+	standbyFails   int       // consecutive failed standby starts
+	standbyRetryAt time.Time // no standby is started before this after a failure
+	closed         bool
+	mu             sync.Mutex
+	sessionMu      sync.Mutex
+	forkServer     *forkServer
+	sched          *scheduler
+	latencies      *latencyTracker
+	handlers       *handlerRegistry
+	events         *eventBus
+	broadcasts     *broadcastLog
+	ring           hashRing // assigns affinity keys to positions in workers
 	tempDir        string
 	ctx            context.Context
+	cfg            poolConfig
+	// shared arrays of the pool by name and the prefix of their paths, see SharedArray
+	sharedArrays      map[string]bool
+	sharedArrayPrefix string
 }
 
-func NewPool(ctx context.Context, scripts embed.FS, executablePath, entryScript string, n int) *Pool {
+func NewPool(ctx context.Context, scripts embed.FS, executablePath, entryScript string, n int, opts ...PoolOption) *Pool {
+	cfg := defaultPoolConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	tempDir, err := os.MkdirTemp("", "")
 	if err != nil {
 		panic("unable to create temporary directory")
@@ -108,23 +133,53 @@ func NewPool(ctx context.Context, scripts embed.FS, executablePath, entryScript
 		executablePath: executablePath,
 		entryScript:    entryScript,
 		workers:        nil,
+		sched:          newScheduler(cfg.priorityAging, cfg.shedTarget),
+		latencies:      newLatencyTracker(),
+		handlers:       newHandlerRegistry(),
+		events:         newEventBus(ctx),
+		broadcasts:     &broadcastLog{},
 		tempDir:        tempDir,
 		ctx:            ctx,
+		cfg:            cfg,
+		sharedArrays:   map[string]bool{},
+		sharedArrayPrefix: filepath.Join(sharedMemoryDir,
+			fmt.Sprintf("gopy-shared-%v-%v-", os.Getpid(), sharedMemoryIDs.Add(1))),
+	}
+	if cfg.forkServer {
+		p.forkServer, err = startForkServer(ctx, executablePath, tempDir, entryScript, cfg.preload, p.workerEnv())
+		if err != nil {
+			panic(fmt.Sprintf("failed to initialise python fork server: %v", err))
+		}
 	}
 	for i := 0; i < n; i++ {
-		w := NewPythonWrapper(ctx, executablePath, tempDir, entryScript)
+		w := p.newWorker()
 		if _, err := w.InitProcess(); err != nil {
 			panic(fmt.Sprintf("failed to initialise python process: %v", err))
 		}
 		p.workers = append(p.workers, w)
+		p.ring.add(i)
+		for j := 0; j < cfg.concurrency; j++ {
+			p.sched.add(w)
+		}
 	}
+	p.replenishStandby()
 	return p
 }
 
 func (p *Pool) Close() {
-	for _, w := range p.workers {
+	p.mu.Lock()
+	p.closed = true
+	workers := append(slices.Clone(p.workers), p.standby...)
+	p.standby = nil
+	p.mu.Unlock()
+	for _, w := range workers {
 		w.Close()
 	}
+	if p.forkServer != nil {
+		p.forkServer.Close()
+	}
+	p.events.close()
+	p.removeSharedArrays()
 	err := os.RemoveAll(p.tempDir)
 	if err != nil {
 		slog.ErrorContext(p.ctx, fmt.Sprintf("deleting temporary dir %v: %v", p.tempDir, err))
@@ -132,6 +187,33 @@ func (p *Pool) Close() {
 	slog.InfoContext(p.ctx, fmt.Sprintf("deleted temporary dir: %v", p.tempDir))
 }
 
+func (p *Pool) newWorker() *PythonWrapper {
+	w := NewPythonWrapper(p.ctx, p.executablePath, p.tempDir, p.entryScript)
+	w.forkServer = p.forkServer
+	w.env = p.workerEnv()
+	w.handlers = p.handlers
+	w.events = p.events
+	w.broadcasts = p.broadcasts
+	w.sharedMemoryThreshold = p.cfg.sharedMemoryThreshold
+	return w
+}
+
+// workerEnv is the environment python workers are started with in addition to the current process environment.
+func (p *Pool) workerEnv() []string {
+	env := []string{fmt.Sprintf("GOPY_WORKER_CONCURRENCY=%v", p.cfg.concurrency)}
+	if p.cfg.widenFloat16 {
+		env = append(env, "GOPY_FLOAT16_AS_FLOAT32=1")
+	}
+	if p.cfg.preserveArrayOrder {
+		env = append(env, "GOPY_PRESERVE_ARRAY_ORDER=1")
+	}
+	if p.cfg.sharedMemoryThreshold > 0 {
+		env = append(env, fmt.Sprintf("GOPY_SHM_THRESHOLD=%v", p.cfg.sharedMemoryThreshold), "GOPY_SHM_DIR="+sharedMemoryDir)
+	}
+	env = append(env, "GOPY_SHARED_ARRAYS="+p.sharedArrayPrefix)
+	return env
+}
+
 func MustCallDefault[T any](pythonFunctionName string, inputObj any) T {
 	res, err := CallPool[T](DefaultPool, pythonFunctionName, inputObj)
 	if err != nil {
@@ -144,13 +226,42 @@ func CallDefault[T any](pythonFunctionName string, inputObj any) (T, error) {
 	return CallPool[T](DefaultPool, pythonFunctionName, inputObj)
 }
 
-func CallPool[T any](w *Pool, pythonFunctionName string, inputObj any) (T, error) {
-	w.nextIndMu.Lock()
-	ind := w.nextInd
-	w.nextInd++
-	w.nextIndMu.Unlock()
-	worker := w.workers[ind%len(w.workers)]
-	return Call[T](worker, pythonFunctionName, inputObj)
+func CallDefaultContext[T any](ctx context.Context, pythonFunctionName string, inputObj any, opts ...CallOption) (T, error) {
+	return CallPoolContext[T](ctx, DefaultPool, pythonFunctionName, inputObj, opts...)
+}
+
+func CallPool[T any](p *Pool, pythonFunctionName string, inputObj any) (T, error) {
+	return CallPoolContext[T](context.Background(), p, pythonFunctionName, inputObj)
+}
+
+// CallPoolContext calls the python function on the next free worker of the pool. If all workers are busy the call is
+// queued according to its priority until a worker frees up or ctx is done. If ctx is done during the call, the worker
+// is only handed to another call once python stops running it, which a synchronous function may not do until it
+// returns.
+func CallPoolContext[T any](ctx context.Context, p *Pool, pythonFunctionName string, inputObj any, opts ...CallOption) (T, error) {
+	var result T
+	cfg := newCallConfig(ctx, opts)
+	ctx = contextWithProgress(ctx, cfg.progress)
+	worker, err := p.acquireCall(ctx, cfg)
+	if err != nil {
+		return result, fmt.Errorf("waiting for free python worker: %w", err)
+	}
+	// a hedge would run on another worker than the one owning the affinity key
+	if cfg.hedgePercentile > 0 && !cfg.hasAffinity {
+		return hedgedCall[T](ctx, p, worker, pythonFunctionName, inputObj, cfg.hedgePercentile)
+	}
+	defer p.release(worker)
+	return callWorker[T](ctx, p, worker, pythonFunctionName, inputObj)
+}
+
+// callWorker calls the python function on a worker of the pool, recording the duration of successful calls.
+func callWorker[T any](ctx context.Context, p *Pool, w *PythonWrapper, pythonFunctionName string, inputObj any) (T, error) {
+	start := time.Now()
+	res, err := CallContext[T](ctx, w, pythonFunctionName, inputObj)
+	if err == nil {
+		p.latencies.observe(pythonFunctionName, time.Since(start))
+	}
+	return res, err
 }
 
 type PythonWrapper struct {
@@ -160,9 +271,23 @@ type PythonWrapper struct {
 	ctx            context.Context
 	cancelCause    context.CancelCauseFunc
 	Com            cmdu.PipeCommunication
-	cmd            *exec.Cmd
-	mu             sync.Mutex
-	parentCtx      context.Context
+	pid            int
+	proc           *process
+	env            []string
+	replacedBy     *PythonWrapper
+	handlers       *handlerRegistry
+	events         *eventBus
+	broadcasts     *broadcastLog
+	broadcastVer   uint64 // version of broadcasts the process has received
+	// arrays of at least this many bytes are passed in shared memory, 0 disables it
+	sharedMemoryThreshold int
+	forkServer            *forkServer
+	mu                    sync.Mutex
+	parentCtx             context.Context
+	// starting is set while the process is being started, during which mu is held, and running is the started
+	// process, so liveness can be checked without waiting for a start in progress, see alive
+	starting atomic.Bool
+	running  atomic.Pointer[process]
 }
 
 func NewPythonWrapper(ctx context.Context, executablePath, workingDir, scriptPath string) *PythonWrapper {
@@ -180,7 +305,7 @@ func NewPythonWrapper(ctx context.Context, executablePath, workingDir, scriptPat
 func (w *PythonWrapper) InitProcess() (int, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	if w.cmd != nil {
+	if w.ctx != nil {
 		if w.ctx.Err() != nil {
 			// todo log reason from ctx
 			slog.WarnContext(w.parentCtx, fmt.Sprintf("python worker process dead (%v), restarting", context.Cause(w.ctx)))
@@ -190,6 +315,8 @@ func (w *PythonWrapper) InitProcess() (int, error) {
 		}
 	}
 
+	w.starting.Store(true)
+	defer w.starting.Store(false)
 	com, err := cmdu.NewPipeCommunication()
 	if err != nil {
 		return 0, fmt.Errorf("failed initialising process communication pipe: %w", err)
@@ -197,14 +324,79 @@ func (w *PythonWrapper) InitProcess() (int, error) {
 
 	ctx, cancelCauseFunc := context.WithCancelCause(w.parentCtx)
 
+	var pid int
+	if w.forkServer != nil && w.forkServer.ctx.Err() == nil {
+		pid, err = w.startForked(ctx, cancelCauseFunc, com)
+	} else {
+		if w.forkServer != nil {
+			slog.WarnContext(ctx, fmt.Sprintf("python fork server dead (%v), starting worker process directly", context.Cause(w.forkServer.ctx)))
+		}
+		pid, err = w.startExec(ctx, cancelCauseFunc, com)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "waiting for python script ready signal")
+
+	buf := []byte("ready")
+	n, err := com.ThisRead.Read(buf)
+	if err != nil {
+		cancelCauseFunc(fmt.Errorf("failed to read 'ready' signal from python script: %w", err))
+		return 0, context.Cause(ctx)
+	} else if n != len(buf) {
+		cancelCauseFunc(fmt.Errorf("failed to read 'ready' signal, expected %v bytes but could only read %v", len(buf), n))
+		return 0, context.Cause(ctx)
+	}
+
+	slog.InfoContext(ctx, "successfully initialised python process")
+	w.ctx = ctx
+	w.cancelCause = cancelCauseFunc
+	w.Com = com
+	w.pid = pid
+	var shared *sharedMemory
+	if w.sharedMemoryThreshold > 0 {
+		shared = newSharedMemory(w.sharedMemoryThreshold, pid)
+	}
+	w.proc = newProcess(ctx, cancelCauseFunc, com, w.handlers, w.events, shared)
+	w.replayBroadcasts(w.proc)
+	w.running.Store(w.proc)
+	return pid, nil
+}
+
+// process returns the running python process, starting it if needed.
+func (w *PythonWrapper) process() (*process, error) {
+	if _, err := w.InitProcess(); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.proc, nil
+}
+
+// claimAbandoned returns a channel closed once an abandoned call still running on the worker's process stops, or nil
+// if there is none, see process.claimAbandoned.
+func (w *PythonWrapper) claimAbandoned() <-chan struct{} {
+	w.mu.Lock()
+	proc := w.proc
+	w.mu.Unlock()
+	if proc == nil {
+		return nil
+	}
+	return proc.claimAbandoned()
+}
+
+// startExec starts the worker as a fresh python process.
+func (w *PythonWrapper) startExec(ctx context.Context, cancelCauseFunc context.CancelCauseFunc, com cmdu.PipeCommunication) (int, error) {
 	cmd := exec.Command(w.executablePath, w.scriptPath)
 	cmd.Dir = w.executableDir
-	cmd.Env = os.Environ()
+	cmd.Env = append(os.Environ(), w.env...)
 	slog.DebugContext(ctx, fmt.Sprintf("start worker process: working dir: %v, executable: %v, script: %v", cmd.Dir, w.executablePath, w.scriptPath))
 	cmd.ExtraFiles = []*os.File{com.OtherRead, com.OtherWrite}
 
 	stdout, stderr, _, closeFunc, err := cmdu.InitStdPipes(cmd)
 	if err != nil {
+		com.CloseAndSwallowErrors()
 		cancelCauseFunc(fmt.Errorf("failed initialising fitter process stdout/stderr: %w", err))
 		return 0, context.Cause(ctx)
 	}
@@ -213,11 +405,15 @@ func (w *PythonWrapper) InitProcess() (int, error) {
 	go consumeStdout(ctx, stdout)
 	go consumeStderr(ctx, stderr)
 	if err := cmd.Start(); err != nil {
+		com.CloseAndSwallowErrors()
 		cancelCauseFunc(fmt.Errorf("failed to start python process: %w", err))
 		return 0, context.Cause(ctx)
 	}
+	// the child holds its own copies, closing ours lets reads fail fast if the child dies
+	_ = com.OtherRead.Close()
+	_ = com.OtherWrite.Close()
 
-	contextu.OnCancel(ctx, func() { _ = cmd.Process.Kill() })
+	contextu.OnCancel(ctx, func() { _ = cmd.Process.Kill() }, com.CloseAndSwallowErrors)
 
 	// handle child process exiting
 	go func() {
@@ -232,77 +428,65 @@ func (w *PythonWrapper) InitProcess() (int, error) {
 		}
 		cancelCauseFunc(nil)
 	}()
+	return cmd.Process.Pid, nil
+}
 
-	slog.InfoContext(ctx, "waiting for python script ready signal")
-
-	buf := []byte("ready")
-	n, err := com.ThisRead.Read(buf)
-	if err != nil {
-		cancelCauseFunc(fmt.Errorf("failed to read 'ready' signal from python script: %w", err))
-		return 0, context.Cause(ctx)
-	} else if n != len(buf) {
-		cancelCauseFunc(fmt.Errorf("failed to read 'ready' signal, expected %v bytes but could only read %v", len(buf), n))
-		return 0, context.Cause(ctx)
+// alive reports whether the worker process is being started, or has been started and has not exited. It doesn't wait
+// for a start in progress, so it can be called with the pool's lock held.
+func (w *PythonWrapper) alive() bool {
+	if w.starting.Load() {
+		return true
 	}
-
-	slog.InfoContext(ctx, "successfully initialised python process")
-	w.ctx = ctx
-	w.cancelCause = cancelCauseFunc
-	w.Com = com
-	w.cmd = cmd
-	return cmd.Process.Pid, nil
+	proc := w.running.Load()
+	return proc != nil && proc.ctx.Err() == nil
 }
 
 func (w *PythonWrapper) Close() {
+	if w.cancelCause == nil {
+		return
+	}
 	w.cancelCause(nil)
 }
 
 func Call[T any](w *PythonWrapper, pythonFunctionName string, inputObj any) (T, error) {
-	var result T
-	if _, err := w.InitProcess(); err != nil {
-		return result, err
-	}
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	inputDataBytes, err := msgpack.Marshal(inputObj)
+	return CallContext[T](context.Background(), w, pythonFunctionName, inputObj)
+}
 
+// CallContext calls the python function on the given worker. If ctx is done before the result arrives the call is
+// abandoned and python is asked to cancel it, though a running synchronous python function is left to complete.
+func CallContext[T any](ctx context.Context, w *PythonWrapper, pythonFunctionName string, inputObj any) (T, error) {
+	proc, err := w.process()
 	if err != nil {
-		return result, fmt.Errorf("couldn't serialse input data %v", err)
-	}
-	if err = cmdu.WriteData([]byte(pythonFunctionName), w.Com.ThisWrite); err != nil {
-		w.cancelCause(fmt.Errorf("failed writing data to child process: %v", err))
-		return result, err
-	}
-	if err = cmdu.WriteData(inputDataBytes, w.Com.ThisWrite); err != nil {
-		w.cancelCause(fmt.Errorf("failed writing data to child process: %v", err))
+		var result T
 		return result, err
 	}
+	return callProcess[T](ctx, proc, frameCall, pythonFunctionName, inputObj)
+}
 
-	var resultData []byte
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-
-	go func() {
-		resultData, err = cmdu.ReadData(w.Com.ThisRead)
-		cancel()
-	}()
-
-	<-ctx.Done()
-	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-		err = fmt.Errorf("python Call timed out: %w", ctx.Err())
-		w.cancelCause(err)
+// callProcess sends a request expecting a single result to the python process.
+func callProcess[T any](ctx context.Context, proc *process, kind int, fields ...any) (T, error) {
+	var result T
+	pc, err := proc.request(ctx, 1, kind, fields...)
+	if err != nil {
 		return result, err
 	}
+	defer proc.finish(pc)
 
+	f, err := proc.nextWithTimeout(ctx, pc)
 	if err != nil {
-		w.cancelCause(fmt.Errorf("failed reading data to child process: %v", err))
 		return result, err
 	}
-	err = msgpack.Unmarshal(resultData, &result)
-	if err != nil {
-		return result, fmt.Errorf("unmarshalling result from child process: %v", err)
+	switch f.kind {
+	case frameResult:
+		if err = f.field(0, &result); err != nil {
+			return result, fmt.Errorf("unmarshalling result from child process: %v", err)
+		}
+		return result, nil
+	case frameError:
+		return result, f.pythonError()
+	default:
+		return result, fmt.Errorf("unexpected frame kind %v from child process", f.kind)
 	}
-	return result, nil
 }
 
 // findRootDir identifies the first directory of the embedded files
@@ -3,10 +3,18 @@ package gopy
 import (
 	"context"
 	"embed"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
 	"math/rand"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed test-scripts/*
@@ -58,6 +66,10 @@ func TestCall(t *testing.T) {
 		testArr = append(testArr, row)
 		testArrFloat32 = append(testArrFloat32, rowFloat32)
 	}
+	ndarray4D := NewNDArray[float64](2, 3, 1, 4)
+	for i := range ndarray4D.Data {
+		ndarray4D.Data[i] = rand.Float64()
+	}
 
 	type testCase struct {
 		name     string
@@ -304,6 +316,65 @@ func TestCall(t *testing.T) {
 			wantErr: false,
 			want:    ArrayWrapperFloat64{Arr2D: Float64_2DArray{{2.5, 1.34}, {1.1, 99.9}}},
 		},
+		{
+			name: "test int16 3D array",
+			exec: func() (any, error) {
+				return CallPool[Int16_3DArray](pp, "identity", Int16_3DArray{{{1, 2, 3}, {4, 5, 6}}, {{-1, -2, -3}, {7, 8, 9}}})
+			},
+			want: Int16_3DArray{{{1, 2, 3}, {4, 5, 6}}, {{-1, -2, -3}, {7, 8, 9}}},
+		},
+		{
+			name: "test int64 3D array",
+			exec: func() (any, error) {
+				return CallPool[Int64_3DArray](pp, "identity", Int64_3DArray{{{1, 2}}, {{3, 1 << 40}}})
+			},
+			want: Int64_3DArray{{{1, 2}}, {{3, 1 << 40}}},
+		},
+		{
+			name: "arrays in a map",
+			exec: func() (any, error) {
+				return CallPool[map[string]any](pp, "identity", map[string]any{"a": Float32_Array{1, 2}, "b": Int16_2DArray{{3}}})
+			},
+			want: map[string]any{
+				"a": NDArray[float32]{Data: []float32{1, 2}, Shape: []int{2}},
+				"b": NDArray[int16]{Data: []int16{3}, Shape: []int{1, 1}},
+			},
+		},
+		{
+			name: "ndarray float64 4D",
+			exec: func() (any, error) {
+				return CallPool[NDArray[float64]](pp, "identity", ndarray4D)
+			},
+			want: ndarray4D,
+		},
+		{
+			name: "ndarray float64 2D",
+			exec: func() (any, error) {
+				return CallPool[NDArray[float64]](pp, "identity", NDArray[float64]{Data: []float64{1.5, 2, 3, 4, 5, 6}, Shape: []int{2, 3}})
+			},
+			want: NDArray[float64]{Data: []float64{1.5, 2, 3, 4, 5, 6}, Shape: []int{2, 3}},
+		},
+		{
+			name: "ndarray uint8 3D",
+			exec: func() (any, error) {
+				return CallPool[NDArray[uint8]](pp, "identity", NDArray[uint8]{Data: []uint8{0, 1, 2, 255}, Shape: []int{1, 2, 2}})
+			},
+			want: NDArray[uint8]{Data: []uint8{0, 1, 2, 255}, Shape: []int{1, 2, 2}},
+		},
+		{
+			name: "ndarray int64 0D",
+			exec: func() (any, error) {
+				return CallPool[NDArray[int64]](pp, "identity", NDArray[int64]{Data: []int64{-7}, Shape: []int{}})
+			},
+			want: NDArray[int64]{Data: []int64{-7}, Shape: []int{}},
+		},
+		{
+			name: "ndarray wrong element type",
+			exec: func() (any, error) {
+				return CallPool[NDArray[int32]](pp, "identity", ndarray4D)
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -317,3 +388,1156 @@ func TestCall(t *testing.T) {
 		})
 	}
 }
+
+func TestHedgedCall(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 2)
+	defer pp.Close()
+
+	for i := 0; i < minHedgeSamples; i++ {
+		pp.latencies.observe("slow_once", 10*time.Millisecond)
+	}
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	start := time.Now()
+	got, err := CallPoolContext[string](context.Background(), pp, "slow_once", map[string]any{"marker": marker}, WithHedging(0.9))
+	if err != nil {
+		t.Fatalf("CallPoolContext() error = %v", err)
+	}
+	if got != "fast" {
+		t.Errorf("CallPoolContext() got = %v, want result of hedged call", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hedged call took %v", elapsed)
+	}
+}
+
+func TestSlowRestart(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(2))
+	defer pp.Close()
+	if _, err := CallPool[int](pp, "getpid", nil); err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+
+	// the worker restarts through a slow interpreter once it crashes
+	slow := filepath.Join(t.TempDir(), "slow-python")
+	if err := os.WriteFile(slow, []byte("#!/bin/sh\nsleep 2\nexec "+pythonEnv+" \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := pp.workers[0]
+	w.mu.Lock()
+	w.executablePath = slow
+	w.mu.Unlock()
+	_, _ = CallPool[any](pp, "crash", nil)
+
+	results := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := CallPool[int](pp, "getpid", nil)
+			results <- err
+		}()
+	}
+	time.Sleep(500 * time.Millisecond)
+	// the pool's lock is free while both slots wait for the restart
+	start := time.Now()
+	shared, err := NewSharedArray(pp, "restart", NDArray[float64]{Data: []float64{1}, Shape: []int{1}})
+	if err != nil {
+		t.Fatalf("NewSharedArray() error = %v", err)
+	}
+	shared.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("NewSharedArray() during restart took %v", elapsed)
+	}
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Errorf("CallPool() after restart error = %v", err)
+		}
+	}
+}
+
+func TestCancelledCallHoldsWorker(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+
+	for _, fn := range []string{"sleep", "async_sleep"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err := CallPoolContext[float64](ctx, pp, fn, map[string]any{"seconds": 1})
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("CallPoolContext(%v) error = %v, want deadline exceeded", fn, err)
+		}
+		if fn == "sleep" {
+			// python keeps running the synchronous function, so its worker isn't free yet
+			ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+			_, err = CallPoolContext[float64](ctx, pp, "sleep", map[string]any{"seconds": 0})
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("CallPoolContext() while cancelled call runs error = %v, want deadline exceeded", err)
+			}
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		got, err := CallPoolContext[float64](ctx, pp, "sleep", map[string]any{"seconds": 0})
+		cancel()
+		if err != nil || got != 0 {
+			t.Errorf("CallPoolContext() after cancelled %v = %v, %v, want 0", fn, got, err)
+		}
+	}
+}
+
+func TestStandbyWorkers(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithStandbyWorkers(1))
+	defer pp.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for standbyReady := false; !standbyReady; {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for standby worker")
+		}
+		time.Sleep(10 * time.Millisecond)
+		pp.mu.Lock()
+		standbyReady = len(pp.standby) == 1
+		pp.mu.Unlock()
+	}
+	original := pp.workers[0]
+
+	if _, err := CallPool[any](pp, "crash", nil); err == nil {
+		t.Fatalf("CallPool() expected error from crashed worker")
+	}
+	got, err := CallPool[AddResult](pp, "add", AddInput{1, 2})
+	if err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	if got.Result != 3 {
+		t.Errorf("CallPool() got = %v, want 3", got.Result)
+	}
+	pp.mu.Lock()
+	replaced := pp.workers[0] != original
+	pp.mu.Unlock()
+	if !replaced {
+		t.Errorf("dead worker was not replaced by standby")
+	}
+}
+
+func TestForkServer(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 2, WithForkServer("json"))
+	defer pp.Close()
+
+	for i := 0; i < 4; i++ {
+		got, err := CallPool[AddResult](pp, "add", AddInput{i + 1, 2})
+		if err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+		if got.Result != i+3 {
+			t.Errorf("CallPool() got = %v, want %v", got.Result, i+3)
+		}
+	}
+
+	if _, err := CallPool[any](pp, "crash", nil); err == nil {
+		t.Fatalf("CallPool() expected error from crashed worker")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := CallPool[AddResult](pp, "add", AddInput{1, 2}); err != nil {
+			t.Fatalf("CallPool() after crash error = %v", err)
+		}
+	}
+}
+
+func TestWorkerConcurrency(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(4))
+	defer pp.Close()
+
+	start := time.Now()
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := CallPool[float64](pp, "sleep", map[string]any{"seconds": 0.5})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("concurrent calls took %v, expected them to run in parallel on one worker", elapsed)
+	}
+
+	pid := pp.workers[0].pid
+	_, err = CallPool[any](pp, "fail", "boom")
+	var pyErr *PythonError
+	if !errors.As(err, &pyErr) || pyErr.Type != "ValueError" || pyErr.Message != "boom" {
+		t.Errorf("CallPool() error = %v, want python ValueError", err)
+	}
+	if _, err := CallPool[AddResult](pp, "add", AddInput{1, 2}); err != nil || pp.workers[0].pid != pid {
+		t.Errorf("worker restarted after python exception, error = %v", err)
+	}
+}
+
+func TestAsyncFunctions(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(32))
+	defer pp.Close()
+
+	start := time.Now()
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		go func() {
+			_, err := CallPool[float64](pp, "async_sleep", map[string]any{"seconds": 0.5})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 32; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("concurrent async calls took %v", elapsed)
+	}
+
+	marker := filepath.Join(t.TempDir(), "cancelled")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := CallPoolContext[float64](ctx, pp, "async_sleep", map[string]any{"seconds": 5, "marker": marker}); err == nil {
+		t.Fatalf("CallPoolContext() expected error from cancelled call")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("python coroutine was not cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamPool(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+	ctx := context.Background()
+
+	for _, fn := range []string{"count", "async_count"} {
+		var got []int
+		for n, err := range StreamPool[int](ctx, pp, fn, map[string]any{"n": 100}, WithStreamBuffer(4)) {
+			if err != nil {
+				t.Fatalf("StreamPool(%v) error = %v", fn, err)
+			}
+			got = append(got, n)
+		}
+		if len(got) != 100 || got[99] != 99 {
+			t.Errorf("StreamPool(%v) yielded %v items, want 100", fn, len(got))
+		}
+	}
+
+	var last int
+	var streamErr error
+	for n, err := range StreamPool[int](ctx, pp, "count", map[string]any{"n": 10, "fail_at": 5}) {
+		if err != nil {
+			streamErr = err
+			break
+		}
+		last = n
+	}
+	var pyErr *PythonError
+	if !errors.As(streamErr, &pyErr) || pyErr.Type != "ValueError" || last != 4 {
+		t.Errorf("StreamPool() error = %v after item %v, want python ValueError after item 4", streamErr, last)
+	}
+
+	marker := filepath.Join(t.TempDir(), "closed")
+	for n, err := range StreamPool[int](ctx, pp, "count", map[string]any{"n": 1000000, "marker": marker}) {
+		if err != nil {
+			t.Fatalf("StreamPool() error = %v", err)
+		}
+		if n == 2 {
+			break
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("python generator was not closed after early break")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got, err := CallPool[[]int](pp, "count", map[string]any{"n": 3}); err != nil || len(got) != 3 {
+		t.Errorf("CallPool() on generator = %v, %v, want materialised list", got, err)
+	}
+}
+
+func TestCallPoolIter(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+	ctx := context.Background()
+
+	numbers := func(yield func(int) bool) {
+		for i := 1; i <= 1000; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	for _, fn := range []string{"sum_stream", "async_sum_stream"} {
+		got, err := CallPoolIter[int](ctx, pp, fn, numbers, WithInputChunkSize(64))
+		if err != nil || got != 500500 {
+			t.Errorf("CallPoolIter(%v) = %v, %v, want 500500", fn, got, err)
+		}
+	}
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= 10; i++ {
+			ch <- i
+		}
+	}()
+	if got, err := CallPoolIter[int](ctx, pp, "sum_stream", FromChannel(ch)); err != nil || got != 55 {
+		t.Errorf("CallPoolIter() from channel = %v, %v, want 55", got, err)
+	}
+}
+
+func TestGoHandlers(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(2))
+	defer pp.Close()
+	HandleFunc(pp, "double", func(ctx context.Context, n int) (int, error) {
+		return 2 * n, nil
+	})
+	pp.Handle("fail", func(ctx context.Context, payload msgpack.RawMessage) (any, error) {
+		return nil, errors.New("lookup failed")
+	})
+
+	for _, fn := range []string{"lookup", "async_lookup"} {
+		got, err := CallPool[[]int](pp, fn, map[string]any{"values": []int{1, 2, 3}})
+		if err != nil || len(got) != 3 || got[2] != 6 {
+			t.Errorf("CallPool(%v) = %v, %v, want [2 4 6]", fn, got, err)
+		}
+	}
+	for handler, want := range map[string]string{"fail": "lookup failed", "missing": "no go handler named missing"} {
+		got, err := CallPool[string](pp, "lookup_error", map[string]any{"handler": handler})
+		if err != nil || got != want {
+			t.Errorf("CallPool(lookup_error) with handler %v = %q, %v, want %q", handler, got, err, want)
+		}
+	}
+}
+
+func TestSlowGoHandler(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	defer func(timeout time.Duration) { callTimeout = timeout }(callTimeout)
+	callTimeout = 200 * time.Millisecond
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+	HandleFunc(pp, "double", func(ctx context.Context, n int) (int, error) {
+		time.Sleep(3 * callTimeout)
+		return 2 * n, nil
+	})
+
+	for _, fn := range []string{"lookup", "async_lookup"} {
+		got, err := CallPool[[]int](pp, fn, map[string]any{"values": []int{1, 2}})
+		if err != nil || len(got) != 2 || got[1] != 4 {
+			t.Errorf("CallPool(%v) with go handler slower than the call timeout = %v, %v, want [2 4]", fn, got, err)
+		}
+	}
+	if _, err := CallPool[float64](pp, "sleep", map[string]any{"seconds": 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CallPool(sleep) error = %v, want call timeout", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+	ctx := context.Background()
+
+	var reports []Progress
+	res, err := CallPoolContext[string](ctx, pp, "fit", map[string]any{"steps": 4}, WithProgress(func(p Progress) {
+		reports = append(reports, p)
+	}))
+	if err != nil || res != "done" {
+		t.Fatalf("CallPoolContext() = %v, %v", res, err)
+	}
+	if len(reports) != 4 || reports[3].Fraction != 1 || reports[0].Message != "step 1" {
+		t.Fatalf("progress reports = %+v", reports)
+	}
+	if step, ok := reports[1].Fields["step"]; !ok || reflect.ValueOf(step).Convert(reflect.TypeOf(0)).Int() != 2 {
+		t.Errorf("progress fields = %v, want step 2", reports[1].Fields)
+	}
+
+	ch := make(chan Progress, 4)
+	if _, err := CallPoolContext[string](ctx, pp, "fit", map[string]any{"steps": 2}, WithProgressChan(ch)); err != nil {
+		t.Fatalf("CallPoolContext() error = %v", err)
+	}
+	if len(ch) != 2 {
+		t.Errorf("received %v progress reports on channel, want 2", len(ch))
+	}
+}
+
+func TestSlowProgress(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(2))
+	defer pp.Close()
+
+	// nothing receives the reports until the other call on the worker returns
+	ch := make(chan Progress)
+	done := make(chan error, 1)
+	go func() {
+		_, err := CallPool[AddResult](pp, "add", AddInput{1, 2})
+		done <- err
+	}()
+	res := make(chan error, 1)
+	go func() {
+		_, err := CallPoolContext[string](context.Background(), pp, "fit", map[string]any{"steps": 3}, WithProgressChan(ch))
+		res <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("call blocked by undelivered progress of another call")
+	}
+	select {
+	case err := <-res:
+		t.Fatalf("CallPoolContext() returned %v before its progress was delivered", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	for i := range 3 {
+		if p := <-ch; p.Message != fmt.Sprintf("step %v", i+1) {
+			t.Errorf("progress report %v = %+v", i, p)
+		}
+	}
+	if err := <-res; err != nil {
+		t.Errorf("CallPoolContext() error = %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	events, unsubscribe := pp.Subscribe("ticks")
+	other, _ := pp.Subscribe("other")
+
+	if _, err := CallPool[bool](pp, "start_ticker", map[string]any{"topic": "ticks", "n": 3}); err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	for want := 0; want < 3; want++ {
+		select {
+		case e := <-events:
+			var payload struct {
+				N int `msgpack:"n"`
+			}
+			if err := e.Decode(&payload); err != nil || e.Topic != "ticks" || payload.N != want {
+				t.Errorf("event = %v %+v, %v, want tick %v", e.Topic, payload, err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %v", want)
+		}
+	}
+	if len(other) != 0 {
+		t.Errorf("subscriber of other topic received %v events", len(other))
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("channel open after unsubscribe")
+	}
+	pp.Close()
+	if _, ok := <-other; ok {
+		t.Errorf("channel open after pool closed")
+	}
+}
+
+func TestHandles(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 2)
+	defer pp.Close()
+	ctx := context.Background()
+
+	released := filepath.Join(t.TempDir(), "released")
+	h, err := CallPoolHandle(ctx, pp, "make_model", map[string]any{"scale": 3, "marker": released})
+	if err != nil || h.Type() != "Model" {
+		t.Fatalf("CallPoolHandle() = %v, %v", h, err)
+	}
+	got, err := CallMethod[[]int](ctx, h, "predict", []int{1, 2})
+	if err != nil || len(got) != 2 || got[1] != 6 {
+		t.Errorf("CallMethod(predict) = %v, %v, want [3 6]", got, err)
+	}
+	for i := 0; i < 10; i++ {
+		pid, err := CallMethod[int](ctx, h, "pid", nil)
+		if err != nil || pid != h.worker.pid {
+			t.Fatalf("CallMethod(pid) = %v, %v, want pid of worker %v holding the object", pid, err, h.worker.pid)
+		}
+		// idle workers are handed out in turn, so interleaved unpinned calls would move an unpinned call between workers
+		if _, err := CallPool[AddResult](pp, "add", AddInput{1, 2}); err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+	}
+	if _, err := CallMethod[any](ctx, h, "missing", nil); err == nil {
+		t.Errorf("CallMethod(missing) expected error")
+	}
+
+	h.Release()
+	if _, err := CallMethod[int](ctx, h, "pid", nil); !errors.Is(err, ErrHandleReleased) {
+		t.Errorf("CallMethod() after Release error = %v, want ErrHandleReleased", err)
+	}
+	waitForFile(t, released)
+
+	collected := filepath.Join(t.TempDir(), "collected")
+	if _, err := CallPoolHandle(ctx, pp, "make_model", map[string]any{"scale": 1, "marker": collected}); err != nil {
+		t.Fatalf("CallPoolHandle() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		if _, err := os.Stat(collected); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("python object not released after handle was garbage collected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := CallPoolHandle(ctx, pp, "add", AddInput{1, 2}); err == nil {
+		t.Errorf("CallPoolHandle() expected error for function not returning a remote object")
+	}
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 3)
+	defer pp.Close()
+	ctx := context.Background()
+
+	pids := make(map[int]bool)
+	for key := 0; key < 8; key++ {
+		var pid int
+		for i := 0; i < 5; i++ {
+			got, err := CallPoolContext[int](ctx, pp, "getpid", nil, WithAffinity(fmt.Sprintf("tenant-%v", key)))
+			if err != nil {
+				t.Fatalf("CallPoolContext() error = %v", err)
+			}
+			if i > 0 && got != pid {
+				t.Fatalf("key %v routed to pid %v and %v", key, pid, got)
+			}
+			pid = got
+		}
+		pids[pid] = true
+	}
+	if len(pids) < 2 {
+		t.Errorf("8 keys all routed to one of 3 workers")
+	}
+
+	// the keys of a dead worker move to the other workers until it is restarted
+	key := "tenant-0"
+	pid, err := CallPoolContext[int](ctx, pp, "getpid", nil, WithAffinity(key))
+	if err != nil {
+		t.Fatalf("CallPoolContext() error = %v", err)
+	}
+	var owner *PythonWrapper
+	others := make(map[int]bool)
+	for _, w := range pp.workers {
+		if w.pid == pid {
+			owner = w
+		} else {
+			others[w.pid] = true
+		}
+	}
+	_, _ = CallPoolContext[any](ctx, pp, "crash", nil, WithAffinity(key))
+	for i := 0; i < 3; i++ {
+		got, err := CallPoolContext[int](ctx, pp, "getpid", nil, WithAffinity(key))
+		if err != nil || !others[got] {
+			t.Fatalf("CallPoolContext() with owner dead ran on pid %v, %v, want one of %v", got, err, others)
+		}
+	}
+	if _, err := owner.InitProcess(); err != nil {
+		t.Fatalf("InitProcess() error = %v", err)
+	}
+	if got, err := CallPoolContext[int](ctx, pp, "getpid", nil, WithAffinity(key)); err != nil || got != owner.pid {
+		t.Errorf("CallPoolContext() after restart ran on pid %v, %v, want restarted owner %v", got, err, owner.pid)
+	}
+
+	// a hedge would run on another worker and return fast
+	for i := 0; i < minHedgeSamples; i++ {
+		pp.latencies.observe("slow_once", 10*time.Millisecond)
+	}
+	marker := filepath.Join(t.TempDir(), "marker")
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	got, err := CallPoolContext[string](ctx, pp, "slow_once", map[string]any{"marker": marker}, WithAffinity("tenant-0"), WithHedging(0.9))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CallPoolContext() with affinity and hedging = %v, %v, want deadline exceeded", got, err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 2, WithWorkerConcurrency(2))
+	defer pp.Close()
+	ctx := context.Background()
+
+	s, err := pp.Session(ctx)
+	if err != nil {
+		t.Fatalf("Session() error = %v", err)
+	}
+	pid, err := CallSession[int](ctx, s, "set_state", map[string]any{"data": 42})
+	if err != nil {
+		t.Fatalf("CallSession() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if got, err := CallSession[int](ctx, s, "get_state", "data"); err != nil || got != 42 {
+			t.Fatalf("CallSession(get_state) = %v, %v, want 42", got, err)
+		}
+		if other, err := CallPool[int](pp, "getpid", nil); err != nil || other == pid {
+			t.Fatalf("CallPool() ran on pid %v, %v, want a worker other than the session's %v", other, err, pid)
+		}
+	}
+
+	if _, err := CallSession[any](ctx, s, "crash", nil); !errors.Is(err, ErrSessionWorkerDied) {
+		t.Errorf("CallSession(crash) error = %v, want ErrSessionWorkerDied", err)
+	}
+	if _, err := CallSession[any](ctx, s, "get_state", "data"); !errors.Is(err, ErrSessionWorkerDied) {
+		t.Errorf("CallSession() after worker died error = %v, want ErrSessionWorkerDied", err)
+	}
+	s.Close()
+	if _, err := CallSession[any](ctx, s, "get_state", "data"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("CallSession() after Close error = %v, want ErrSessionClosed", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := CallPool[int](pp, "getpid", nil); err != nil {
+			t.Fatalf("CallPool() after session error = %v", err)
+		}
+	}
+}
+
+func TestSessionWorkerDiesWhileWaiting(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithWorkerConcurrency(2), WithStandbyWorkers(1))
+	defer pp.Close()
+	waitForStandby(t, pp, 1)
+	worker := pp.workers[0]
+
+	// the session takes one slot and waits for the one held by the call
+	go func() { _, _ = CallPool[float64](pp, "sleep", map[string]any{"seconds": 1}) }()
+	time.Sleep(200 * time.Millisecond)
+	res := make(chan error, 1)
+	go func() {
+		s, err := pp.Session(context.Background())
+		if err == nil {
+			s.Close()
+		}
+		res <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	worker.Close()
+	select {
+	case err := <-res:
+		if !errors.Is(err, ErrSessionWorkerDied) {
+			t.Errorf("Session() error = %v, want ErrSessionWorkerDied", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Session() still waiting for replaced worker")
+	}
+	if _, err := CallPool[int](pp, "getpid", nil); err != nil {
+		t.Errorf("CallPool() after failed session error = %v", err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	ctx := context.Background()
+	for _, standby := range []int{0, 1} {
+		pp := NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 2, WithStandbyWorkers(standby))
+		waitForStandby(t, pp, standby)
+
+		results, err := Broadcast[int](ctx, pp, "set_state", map[string]any{"model": "v1"})
+		if err != nil {
+			t.Fatalf("Broadcast() error = %v", err)
+		}
+		if _, err = Broadcast[int](ctx, pp, "set_state", map[string]any{"model": "v2"}); err != nil {
+			t.Fatalf("Broadcast() error = %v", err)
+		}
+		pids := make(map[int]bool)
+		for _, r := range results {
+			if r.Err != nil || r.Result != r.Pid {
+				t.Errorf("broadcast result = %+v", r)
+			}
+			pids[r.Pid] = true
+		}
+		if len(results) != 2+standby || len(pids) != 2+standby {
+			t.Errorf("broadcast reached %v workers, want %v", len(pids), 2+standby)
+		}
+
+		// workers started after the broadcast receive the latest state
+		_, _ = CallPool[any](pp, "crash", nil)
+		for i := 0; i < 4; i++ {
+			if got, err := CallPool[string](pp, "get_state", "model"); err != nil || got != "v2" {
+				t.Errorf("with %v standby, CallPool(get_state) = %q, %v, want v2", standby, got, err)
+			}
+		}
+		pp.Close()
+	}
+}
+
+func TestBroadcastWorkerDiesWhileWaiting(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithStandbyWorkers(1))
+	defer pp.Close()
+	waitForStandby(t, pp, 1)
+	worker := pp.workers[0]
+
+	// the broadcast waits for the worker's slot held by the call
+	go func() { _, _ = CallPool[float64](pp, "sleep", map[string]any{"seconds": 1}) }()
+	time.Sleep(200 * time.Millisecond)
+	res := make(chan []BroadcastResult[int], 1)
+	go func() {
+		results, _ := Broadcast[int](context.Background(), pp, "set_state", map[string]any{"model": "v1"})
+		res <- results
+	}()
+	time.Sleep(200 * time.Millisecond)
+	worker.Close()
+	select {
+	case results := <-res:
+		if len(results) != 2 || results[0].Err == nil || results[1].Err != nil {
+			t.Errorf("Broadcast() = %+v, want an error for the dead worker only", results)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Broadcast() still waiting for replaced worker")
+	}
+	if got, err := CallPool[string](pp, "get_state", "model"); err != nil || got != "v1" {
+		t.Errorf("CallPool(get_state) = %q, %v, want v1", got, err)
+	}
+}
+
+func TestStandbyBackoff(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithStandbyWorkers(1))
+	defer pp.Close()
+	waitForStandby(t, pp, 1)
+
+	// standbys started from here on fail, each attempt leaving a line in attempts
+	dir := t.TempDir()
+	attempts := filepath.Join(dir, "attempts")
+	failing := filepath.Join(dir, "failing-python")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho >> "+attempts+"\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	pp.mu.Lock()
+	pp.executablePath = failing
+	for _, sb := range pp.standby {
+		sb.Close()
+	}
+	pp.standby = nil
+	pp.mu.Unlock()
+
+	for i := 0; i < 20; i++ {
+		if _, err := CallPool[AddResult](pp, "add", AddInput{1, 2}); err != nil {
+			t.Fatalf("CallPool() error = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b, _ := os.ReadFile(attempts)
+	if n := strings.Count(string(b), "\n"); n != 1 {
+		t.Errorf("standby was started %v times during 20 calls, want 1", n)
+	}
+}
+
+func waitForStandby(t *testing.T, pp *Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pp.mu.Lock()
+		ready := len(pp.standby)
+		pp.mu.Unlock()
+		if ready >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v standby workers", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDtypes(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+
+	roundTrip := func(in any) {
+		t.Helper()
+		out := reflect.New(reflect.TypeOf(in))
+		got, err := CallPool[msgpack.RawMessage](pp, "identity", in)
+		if err == nil {
+			err = msgpack.Unmarshal(got, out.Interface())
+		}
+		if err != nil || !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Errorf("identity(%+v) = %+v, %v", in, out.Elem().Interface(), err)
+		}
+	}
+	roundTrip(NDArray[bool]{Data: []bool{true, false, true, true}, Shape: []int{2, 2}})
+	roundTrip(NDArray[int8]{Data: []int8{-128, 0, 127}, Shape: []int{3}})
+	roundTrip(NDArray[uint16]{Data: []uint16{0, 65535}, Shape: []int{2}})
+	roundTrip(NDArray[uint32]{Data: []uint32{1, 4294967295}, Shape: []int{2, 1}})
+	roundTrip(NDArray[uint64]{Data: []uint64{1, 18446744073709551615}, Shape: []int{1, 2}})
+	roundTrip(NDArray[Float16]{Data: []Float16{Float16From(1.5), Float16From(-0.25)}, Shape: []int{2}})
+	roundTrip(NDArray[complex64]{Data: []complex64{1 + 2i, -3.5i}, Shape: []int{2}})
+	roundTrip(NDArray[complex128]{Data: []complex128{1 + 2i, -3.5i, 0}, Shape: []int{3, 1, 1}})
+
+	half, err := CallPool[NDArray[Float16]](pp, "make_array", map[string]any{"values": []float64{1.5, 2}, "dtype": "float16"})
+	if err != nil || len(half.Data) != 2 || half.Data[0].Float32() != 1.5 || half.Data[1].Float32() != 2 {
+		t.Errorf("CallPool(make_array float16) = %+v, %v", half, err)
+	}
+	if got, err := CallPool[NDArray[float64]](pp, "identity", nil); err != nil || got.Data != nil || got.Shape != nil {
+		t.Errorf("CallPool[NDArray](identity) of None = %+v, %v, want zero array", got, err)
+	}
+	// numpy types aliasing a supported dtype, e.g. longlong being int64 on most platforms, are sent as that dtype
+	if got, err := CallPool[NDArray[int64]](pp, "make_array", map[string]any{"values": []int{-1, 2}, "dtype": "longlong"}); err != nil || got.Data[0] != -1 {
+		t.Errorf("CallPool(make_array longlong) = %+v, %v", got, err)
+	}
+	if got, err := CallPool[NDArray[uint64]](pp, "make_array", map[string]any{"values": []int{1, 2}, "dtype": "ulonglong"}); err != nil || got.Data[1] != 2 {
+		t.Errorf("CallPool(make_array ulonglong) = %+v, %v", got, err)
+	}
+	_, err = CallPool[any](pp, "make_text_array", []string{"a", "b"})
+	var pyErr *PythonError
+	if !errors.As(err, &pyErr) || pyErr.Type != "UnsupportedTypeError" || !strings.Contains(pyErr.Message, "dtype") {
+		t.Errorf("CallPool(make_text_array) error = %v, want UnsupportedTypeError", err)
+	}
+	if got, err := CallPool[int](pp, "add_scalar_output", AddInput{1, 2}); err != nil || got != 3 {
+		t.Errorf("CallPool() after unsupported dtype = %v, %v", got, err)
+	}
+
+	widening := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1, WithFloat16AsFloat32())
+	defer widening.Close()
+	wide, err := CallPool[NDArray[float32]](widening, "make_array", map[string]any{"values": []float64{1.5, 2}, "dtype": "float16"})
+	if err != nil || !reflect.DeepEqual(wide.Data, []float32{1.5, 2}) {
+		t.Errorf("CallPool(make_array float16) with WithFloat16AsFloat32 = %+v, %v", wide, err)
+	}
+}
+
+func TestSharedMemory(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	ctx := context.Background()
+	pp := NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 1, WithSharedMemory(1024))
+	defer pp.Close()
+
+	large := NewNDArray[float64](64, 32)
+	for i := range large.Data {
+		large.Data[i] = rand.Float64()
+	}
+	small := NDArray[int32]{Data: []int32{1, 2, 3}, Shape: []int{3}}
+	got, err := CallPool[map[string]any](pp, "identity", map[string]any{"large": large, "small": small})
+	if err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	if !reflect.DeepEqual(got["large"], large) || !reflect.DeepEqual(got["small"], small) {
+		t.Errorf("CallPool() = %v, want the arrays sent", got)
+	}
+
+	// the result of python is passed in shared memory too
+	w, err := pp.acquire(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	proc, err := w.process()
+	if err != nil {
+		t.Fatalf("process() error = %v", err)
+	}
+	pc, err := proc.request(ctx, 1, frameCall, "identity", large)
+	if err != nil {
+		t.Fatalf("request() error = %v", err)
+	}
+	f, err := proc.nextWithTimeout(ctx, pc)
+	if err != nil || f.kind != frameResult || len(f.shared) != 1 {
+		t.Fatalf("result frame = %+v, %v, want a result in shared memory", f, err)
+	}
+	var result NDArray[float64]
+	if err := f.field(0, &result); err != nil || !reflect.DeepEqual(result, large) {
+		t.Errorf("shared result = %v, %v", result.Shape, err)
+	}
+	proc.finish(pc)
+	pp.release(w)
+
+	// segments of results that aren't decoded are removed when the call finishes
+	if _, err := CallPool[AddResult](pp, "identity", map[string]any{"result": 1, "large": large}); err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	pid, err := CallPool[int](pp, "getpid", nil)
+	if err != nil {
+		t.Fatalf("CallPool(getpid) error = %v", err)
+	}
+	for _, prefix := range []string{fmt.Sprintf("gopy-go-%v-", os.Getpid()), fmt.Sprintf("gopy-py-%v-", pid)} {
+		if left, _ := filepath.Glob(filepath.Join(sharedMemoryDir, prefix+"*")); len(left) > 0 {
+			t.Errorf("shared memory segments left over: %v", left)
+		}
+	}
+}
+
+func TestSharedArray(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	ctx := context.Background()
+	pp := NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 2)
+	defer pp.Close()
+
+	embeddings := NewNDArray[float32](4, 3)
+	for i := range embeddings.Data {
+		embeddings.Data[i] = rand.Float32()
+	}
+	s, err := NewSharedArray(pp, "embeddings", embeddings)
+	if err != nil {
+		t.Fatalf("NewSharedArray() error = %v", err)
+	}
+	if _, err := NewSharedArray(pp, "embeddings", embeddings); err == nil {
+		t.Errorf("NewSharedArray() with existing name succeeded")
+	}
+	if _, err := NewSharedArray(pp, "../embeddings", embeddings); err == nil {
+		t.Errorf("NewSharedArray() with invalid name succeeded")
+	}
+	// every worker maps the array
+	results, err := Broadcast[NDArray[float32]](ctx, pp, "read_shared_array", "embeddings")
+	if err != nil {
+		t.Fatalf("Broadcast() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Err != nil || !reflect.DeepEqual(r.Result, embeddings) {
+			t.Errorf("Broadcast() = %v, %v, want %v", r.Result, r.Err, embeddings)
+		}
+	}
+
+	updated := NDArray[float32]{Data: []float32{1, 2}, Shape: []int{1, 2}}
+	if err := s.Update(updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if s.Generation() != 2 {
+		t.Errorf("Generation() = %v, want 2", s.Generation())
+	}
+	for range 4 {
+		got, err := CallPool[NDArray[float32]](pp, "read_shared_array", "embeddings")
+		if err != nil || !reflect.DeepEqual(got, updated) {
+			t.Errorf("CallPool() = %v, %v, want %v", got, err, updated)
+		}
+	}
+	// only the current generation is kept
+	paths, _ := filepath.Glob(pp.sharedArrayPath("embeddings") + "*")
+	if len(paths) != 2 {
+		t.Errorf("shared array files = %v, want the reference and one generation", paths)
+	}
+
+	s.Close()
+	if err := s.Update(updated); !errors.Is(err, ErrSharedArrayClosed) {
+		t.Errorf("Update() after Close() error = %v, want %v", err, ErrSharedArrayClosed)
+	}
+	if _, err := CallPool[NDArray[float32]](pp, "read_shared_array", "embeddings"); err == nil {
+		t.Errorf("CallPool() after Close() succeeded")
+	}
+	if paths, _ := filepath.Glob(pp.sharedArrayPath("embeddings") + "*"); len(paths) != 0 {
+		t.Errorf("shared array files left after Close() = %v", paths)
+	}
+}
+
+func TestFileArray(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	ctx := context.Background()
+	pp := NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+
+	dir := t.TempDir()
+	a := NDArray[float64]{Data: []float64{1, 2, 3, 4, 5, 6}, Shape: []int{2, 3}}
+	input, err := WriteFileArray(filepath.Join(dir, "input.bin"), a)
+	if err != nil {
+		t.Fatalf("WriteFileArray() error = %v", err)
+	}
+	type result struct {
+		Input  FileArray[float64] `msgpack:"input"`
+		Output FileArray[float64] `msgpack:"output"`
+		Sum    float64            `msgpack:"sum"`
+	}
+	output := filepath.Join(dir, "output.bin")
+	got, err := CallPool[result](pp, "scale_file_array", map[string]any{"input": input, "factor": 2.0, "output": output})
+	if err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	if got.Sum != 21 {
+		t.Errorf("sum = %v, want 21", got.Sum)
+	}
+	if !reflect.DeepEqual(got.Input, input) {
+		t.Errorf("input = %+v, want %+v", got.Input, input)
+	}
+	m, err := got.Output.Map()
+	if err != nil {
+		t.Fatalf("Map() error = %v", err)
+	}
+	defer m.Close()
+	want := NDArray[float64]{Data: []float64{2, 4, 6, 8, 10, 12}, Shape: []int{2, 3}}
+	if !reflect.DeepEqual(m.NDArray, want) {
+		t.Errorf("Map() = %v, want %v", m.NDArray, want)
+	}
+
+	// decoded into an NDArray the array is read from the file
+	arr, err := CallPool[map[string]any](pp, "scale_file_array", map[string]any{"input": input, "factor": 1.0, "output": output})
+	if err != nil {
+		t.Fatalf("CallPool() error = %v", err)
+	}
+	if !reflect.DeepEqual(arr["output"], a) {
+		t.Errorf("output = %v, want %v", arr["output"], a)
+	}
+}
+
+func TestArrayOrder(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	ctx := context.Background()
+	values := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	c := NDArray[float64]{Data: []float64{1, 2, 3, 4, 5, 6}, Shape: []int{2, 3}}
+
+	pp := NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 1)
+	got, err := CallPool[NDArray[float64]](pp, "make_fortran_array", values)
+	if err != nil || !reflect.DeepEqual(got, c) {
+		t.Errorf("CallPool() = %+v, %v, want %+v normalised to C order", got, err, c)
+	}
+	pp.Close()
+
+	pp = NewPool(ctx, scriptsFS, pythonEnv, "test_script.py", 1, WithArrayOrderPreserved())
+	defer pp.Close()
+	got, err = CallPool[NDArray[float64]](pp, "make_fortran_array", values)
+	if err != nil || !reflect.DeepEqual(got, c.AsOrder(OrderF)) {
+		t.Errorf("CallPool() = %+v, %v, want %+v", got, err, c.AsOrder(OrderF))
+	}
+
+	type layout struct {
+		Fortran bool        `msgpack:"fortran"`
+		Values  [][]float64 `msgpack:"values"`
+	}
+	want := layout{Fortran: true, Values: [][]float64{{1, 4}, {2, 5}, {3, 6}}}
+	if got, err := CallPool[layout](pp, "array_layout", c.Transpose()); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("CallPool() = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestRagged(t *testing.T) {
+	pythonEnv, err := exec.LookPath("python3")
+	if err != nil {
+		t.Fatalf("python3 not found: %v", err)
+	}
+	pp := NewPool(context.Background(), scriptsFS, pythonEnv, "test_script.py", 1)
+	defer pp.Close()
+
+	in := Ragged[int32]{{1, 2, 3}, {4}, {}}
+	lengths, err := CallPool[[]int](pp, "ragged_lengths", in)
+	if err != nil || !reflect.DeepEqual(lengths, []int{3, 1, 0}) {
+		t.Errorf("CallPool() = %v, %v, want the lengths of the 1-d arrays", lengths, err)
+	}
+	got, err := CallPool[Ragged[int32]](pp, "identity", in)
+	if err != nil || !reflect.DeepEqual(got, in) {
+		t.Errorf("CallPool() = %v, %v, want %v", got, err, in)
+	}
+	if _, err := CallPool[any](pp, "identity", Int32_2DArray{{1, 2}, {3}}); err == nil {
+		t.Errorf("CallPool() with ragged Int32_2DArray error = nil, want error")
+	}
+}
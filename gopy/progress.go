@@ -0,0 +1,101 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Progress is an interim report sent by python with report_progress during a call.
+type Progress struct {
+	// Fraction of the work completed, from 0 to 1.
+	Fraction float64
+	Message  string
+	// Fields holds any additional keyword arguments passed to report_progress.
+	Fields map[string]any
+}
+
+type progressKey struct{}
+
+func contextWithProgress(ctx context.Context, fn func(context.Context, Progress)) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFromContext(ctx context.Context) func(context.Context, Progress) {
+	fn, _ := ctx.Value(progressKey{}).(func(context.Context, Progress))
+	return fn
+}
+
+// progressQueue holds the progress reports of a call waiting to be passed to its progress callback. Reports are
+// delivered in order on a goroutine of their own, so a slow callback doesn't hold up the frames of other calls.
+type progressQueue struct {
+	mu      sync.Mutex
+	reports []Progress
+	// idle is closed once the queued reports are delivered, nil while there are none
+	idle chan struct{}
+}
+
+// reportProgress queues a progress frame for the call's progress callback, if any.
+func (pc *pendingCall) reportProgress(f frame) {
+	if pc.progress == nil {
+		return
+	}
+	var p Progress
+	if err := f.field(0, &p.Fraction); err != nil {
+		slog.WarnContext(pc.ctx, fmt.Sprintf("decoding progress of request %v: %v", pc.id, err))
+		return
+	}
+	_ = f.field(1, &p.Message)
+	_ = f.field(2, &p.Fields)
+	q := &pc.progressQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reports = append(q.reports, p)
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+		go pc.deliverProgress(q.idle)
+	}
+}
+
+// deliverProgress passes the queued reports to the progress callback until there are none left. Reports still queued
+// once the call is abandoned are dropped.
+func (pc *pendingCall) deliverProgress(idle chan struct{}) {
+	q := &pc.progressQueue
+	for {
+		q.mu.Lock()
+		select {
+		case <-pc.finished:
+			q.reports = nil
+		default:
+		}
+		if len(q.reports) == 0 {
+			q.reports, q.idle = nil, nil
+			q.mu.Unlock()
+			close(idle)
+			return
+		}
+		p := q.reports[0]
+		q.reports = q.reports[1:]
+		q.mu.Unlock()
+		pc.progress(pc.ctx, p)
+	}
+}
+
+// waitProgress waits until the reports received so far are delivered or ctx is done.
+func (pc *pendingCall) waitProgress(ctx context.Context) {
+	q := &pc.progressQueue
+	q.mu.Lock()
+	idle := q.idle
+	q.mu.Unlock()
+	if idle == nil {
+		return
+	}
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+}
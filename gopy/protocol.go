@@ -0,0 +1,403 @@
+package gopy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jptrs93/goutil/cmdu"
+	"github.com/vmihailenco/msgpack/v5"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frames exchanged with the python worker are length prefixed msgpack arrays of the form [kind, request id, ...]. The
+// request id lets several calls be in flight on one worker, each frame sent by python being routed to the call it
+// belongs to.
+const (
+	// go -> python
+	frameCall           = 1 // [kind, id, function name, input, (options)]
+	frameCancel         = 2 // [kind, id]
+	frameCredit         = 3 // [kind, id, number of further stream items python may send]
+	frameInputChunk     = 4 // [kind, id, [items of the input stream]]
+	frameInputEnd       = 5 // [kind, id]
+	frameCallbackResult = 6 // [kind, id, callback id, result]
+	frameCallbackError  = 7 // [kind, id, callback id, message]
+	frameMethod         = 8 // [kind, id, handle id, method name, input]
+	frameRelease        = 9 // [kind, 0, handle id]
+
+	// python -> go
+	frameResult    = 101 // [kind, id, result]
+	frameError     = 102 // [kind, id, exception type, message, traceback]
+	frameItem      = 103 // [kind, id, item yielded by a generator]
+	frameEnd       = 104 // [kind, id]
+	framePull      = 105 // [kind, id], asks for the next chunk of the input stream
+	frameCallback  = 106 // [kind, id, callback id, handler name, payload], calls a go handler
+	frameProgress  = 107 // [kind, id, fraction, message, fields]
+	frameEvent     = 108 // [kind, 0, topic, payload], published independently of any call
+	frameCancelled = 109 // [kind, id], a cancelled call has stopped running
+)
+
+// finalFrame reports whether a frame of the given kind is the last python sends for a request.
+func finalFrame(kind int) bool {
+	return kind == frameResult || kind == frameError || kind == frameEnd || kind == frameCancelled
+}
+
+// PythonError is returned when the python function raised an exception.
+type PythonError struct {
+	Type      string
+	Message   string
+	Traceback string
+}
+
+func (e *PythonError) Error() string {
+	return fmt.Sprintf("python %v: %v", e.Type, e.Message)
+}
+
+type frame struct {
+	kind   int
+	id     uint64
+	fields []msgpack.RawMessage
+	// shared memory segments the frame's arrays are in
+	shared []string
+}
+
+// encodeFrame encodes a frame into b. Arrays in the fields are referenced by b rather than copied into it.
+func encodeFrame(b *frameBuffer, kind int, id uint64, fields ...any) error {
+	enc := msgpack.NewEncoder(b)
+	if err := enc.EncodeArrayLen(2 + len(fields)); err != nil {
+		return err
+	}
+	if err := enc.EncodeInt(int64(kind)); err != nil {
+		return err
+	}
+	if err := enc.EncodeUint(id); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := enc.Encode(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeFrame decodes the kind and id of a frame. Its fields are slices of data, decoded when they are used.
+func decodeFrame(data []byte) (frame, error) {
+	// the decoder reads straight from r without buffering, so r tells where the fields start
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return frame{}, fmt.Errorf("decoding frame: %w", err)
+	}
+	if n < 2 {
+		return frame{}, fmt.Errorf("decoding frame: expected at least 2 elements but got %v", n)
+	}
+	var f frame
+	if err := dec.Decode(&f.kind); err != nil {
+		return frame{}, fmt.Errorf("decoding frame kind: %w", err)
+	}
+	if err := dec.Decode(&f.id); err != nil {
+		return frame{}, fmt.Errorf("decoding frame id: %w", err)
+	}
+	pos := len(data) - r.Len()
+	for i := 2; i < n; i++ {
+		end, err := msgpackValueEnd(data, pos, func(extID int8, payload []byte) {
+			if path, ok := sharedSegment(extID, payload); ok {
+				f.shared = append(f.shared, path)
+			}
+		})
+		if err != nil {
+			return frame{}, fmt.Errorf("decoding frame: %w", err)
+		}
+		f.fields = append(f.fields, data[pos:end])
+		pos = end
+	}
+	return f, nil
+}
+
+// field unmarshals the i-th field of the frame into v.
+func (f frame) field(i int, v any) error {
+	if i >= len(f.fields) {
+		return fmt.Errorf("frame kind %v has no field %v", f.kind, i)
+	}
+	return msgpack.Unmarshal(f.fields[i], v)
+}
+
+// pythonError builds the error carried by an error frame.
+func (f frame) pythonError() error {
+	e := &PythonError{}
+	if err := f.field(0, &e.Type); err != nil {
+		return fmt.Errorf("decoding python error: %w", err)
+	}
+	_ = f.field(1, &e.Message)
+	_ = f.field(2, &e.Traceback)
+	return e
+}
+
+// process is a running python worker process and the calls in flight on it.
+type process struct {
+	ctx         context.Context
+	cancelCause context.CancelCauseFunc
+	com         cmdu.PipeCommunication
+	handlers    *handlerRegistry
+	events      *eventBus
+	// nil unless large arrays are passed in shared memory
+	sharedMemory *sharedMemory
+
+	writeMu   sync.Mutex
+	nextID    atomic.Uint64
+	pendingMu sync.Mutex
+	pending   map[uint64]*pendingCall
+	// abandoned holds the calls go stopped waiting for that python is still running, until python sends their last
+	// frame, see cancel
+	abandoned map[uint64]*abandonedCall
+}
+
+type abandonedCall struct {
+	done chan struct{}
+	// whether a worker slot release is waiting for the call
+	claimed bool
+}
+
+// pendingCall receives the frames python sends for one request.
+type pendingCall struct {
+	id       uint64
+	frames   chan frame
+	finished chan struct{}
+	once     sync.Once
+	// ctx is the context of the go call, go handlers called back by python during the call run with it
+	ctx           context.Context
+	progress      func(context.Context, Progress)
+	progressQueue progressQueue
+	// shared memory segments of the frames received for the call, removed when it finishes if they weren't decoded
+	sharedMu sync.Mutex
+	shared   []string
+	// whether python sent the last frame of the call, guarded by the process's pendingMu
+	settled bool
+	// callbacks counts the go handlers python is waiting on, during which the call timeout is paused
+	callbacks atomic.Int32
+	// alive is signalled when a go handler returns or python reports progress, restarting the call timeout
+	alive chan struct{}
+}
+
+// touch restarts the call timeout.
+func (pc *pendingCall) touch() {
+	select {
+	case pc.alive <- struct{}{}:
+	default:
+	}
+}
+
+func newProcess(ctx context.Context, cancelCause context.CancelCauseFunc, com cmdu.PipeCommunication, handlers *handlerRegistry, events *eventBus, shared *sharedMemory) *process {
+	p := &process{
+		ctx:          ctx,
+		cancelCause:  cancelCause,
+		com:          com,
+		handlers:     handlers,
+		events:       events,
+		sharedMemory: shared,
+		pending:      make(map[uint64]*pendingCall),
+		abandoned:    make(map[uint64]*abandonedCall),
+	}
+	go p.readFrames()
+	return p
+}
+
+// readFrames routes frames from python to the pending call they belong to until the process dies.
+func (p *process) readFrames() {
+	if p.sharedMemory != nil {
+		defer p.sharedMemory.removeAll()
+	}
+	defer p.settleAbandoned()
+	for {
+		data, err := cmdu.ReadData(p.com.ThisRead)
+		if err != nil {
+			p.cancelCause(fmt.Errorf("failed reading data from child process: %v", err))
+			return
+		}
+		f, err := decodeFrame(data)
+		if err != nil {
+			p.cancelCause(err)
+			return
+		}
+		if f.kind == frameEvent {
+			p.publishEvent(f)
+			continue
+		}
+		p.pendingMu.Lock()
+		pc, ok := p.pending[f.id]
+		if ok && finalFrame(f.kind) {
+			pc.settled = true
+		}
+		if a, abandoned := p.abandoned[f.id]; abandoned && finalFrame(f.kind) {
+			delete(p.abandoned, f.id)
+			close(a.done)
+		}
+		p.pendingMu.Unlock()
+		if !ok {
+			// the call was abandoned, e.g. cancelled, so nobody is waiting for it anymore
+			slog.DebugContext(p.ctx, fmt.Sprintf("dropping frame kind %v for finished request %v", f.kind, f.id))
+			removeSegments(f.shared)
+			continue
+		}
+		if len(f.shared) > 0 {
+			pc.sharedMu.Lock()
+			pc.shared = append(pc.shared, f.shared...)
+			pc.sharedMu.Unlock()
+		}
+		if f.kind == frameProgress {
+			pc.reportProgress(f)
+			pc.touch()
+			continue
+		}
+		if f.kind == frameCallback {
+			pc.callbacks.Add(1)
+			go p.handleCallback(pc, f)
+			continue
+		}
+		select {
+		case pc.frames <- f:
+		case <-pc.finished:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// write sends a frame to python.
+func (p *process) write(kind int, id uint64, fields ...any) error {
+	b := frameBuffer{sharedMemory: p.sharedMemory}
+	if err := encodeFrame(&b, kind, id, fields...); err != nil {
+		removeSegments(b.shared)
+		return fmt.Errorf("couldn't serialse input data %v", err)
+	}
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := b.WriteTo(p.com.ThisWrite); err != nil {
+		removeSegments(b.shared)
+		p.cancelCause(fmt.Errorf("failed writing data to child process: %v", err))
+		return err
+	}
+	return nil
+}
+
+// request registers a new pending call and sends its opening frame. Up to buffer frames of the call are queued
+// without blocking frames of other calls.
+func (p *process) request(ctx context.Context, buffer int, kind int, fields ...any) (*pendingCall, error) {
+	pc := &pendingCall{
+		id:       p.nextID.Add(1),
+		ctx:      ctx,
+		progress: progressFromContext(ctx),
+		frames:   make(chan frame, buffer),
+		finished: make(chan struct{}),
+		alive:    make(chan struct{}, 1),
+	}
+	p.pendingMu.Lock()
+	p.pending[pc.id] = pc
+	p.pendingMu.Unlock()
+	if err := p.write(kind, pc.id, fields...); err != nil {
+		p.finish(pc)
+		return nil, err
+	}
+	return pc, nil
+}
+
+// finish stops routing frames to the call.
+func (p *process) finish(pc *pendingCall) {
+	p.pendingMu.Lock()
+	delete(p.pending, pc.id)
+	p.pendingMu.Unlock()
+	pc.once.Do(func() { close(pc.finished) })
+	pc.sharedMu.Lock()
+	removeSegments(pc.shared)
+	pc.shared = nil
+	pc.sharedMu.Unlock()
+}
+
+// nextWithTimeout waits for the next frame of the call, killing the worker if python stays silent for longer than
+// callTimeout and asking python to cancel the call if ctx is done. The timeout is paused while python waits on go
+// handlers and restarts when they return. Progress reports, which the reader passes to the call's progress callback,
+// also restart the timeout so long calls can keep themselves alive, and are all delivered before the last frame of the
+// call is returned.
+func (p *process) nextWithTimeout(ctx context.Context, pc *pendingCall) (frame, error) {
+	f, err := p.nextBeforeTimeout(ctx, pc)
+	if err == nil && finalFrame(f.kind) {
+		pc.waitProgress(ctx)
+	}
+	return f, err
+}
+
+func (p *process) nextBeforeTimeout(ctx context.Context, pc *pendingCall) (frame, error) {
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case f := <-pc.frames:
+			return f, nil
+		case <-pc.alive:
+			timer.Reset(callTimeout)
+		case <-timer.C:
+			select {
+			case <-pc.alive:
+				// a go handler returned as the timer fired
+				timer.Reset(callTimeout)
+				continue
+			default:
+			}
+			if pc.callbacks.Load() > 0 {
+				// python is waiting on a slow go handler rather than hung
+				timer.Reset(callTimeout)
+				continue
+			}
+			err := fmt.Errorf("python Call timed out: %w", context.DeadlineExceeded)
+			p.cancelCause(err)
+			return frame{}, err
+		case <-ctx.Done():
+			p.cancel(pc)
+			return frame{}, fmt.Errorf("python Call cancelled: %w", context.Cause(ctx))
+		case <-p.ctx.Done():
+			return frame{}, fmt.Errorf("python process died: %w", context.Cause(p.ctx))
+		}
+	}
+}
+
+// cancel abandons the call, asking python to stop working on it where possible. Python may keep running it, e.g. a
+// synchronous function, so the call is tracked until python sends its last frame, see claimAbandoned.
+func (p *process) cancel(pc *pendingCall) {
+	p.pendingMu.Lock()
+	if !pc.settled && p.ctx.Err() == nil {
+		p.abandoned[pc.id] = &abandonedCall{done: make(chan struct{})}
+	}
+	p.pendingMu.Unlock()
+	p.finish(pc)
+	if err := p.write(frameCancel, pc.id); err != nil {
+		slog.DebugContext(p.ctx, fmt.Sprintf("sending cancel for request %v: %v", pc.id, err))
+	}
+}
+
+// claimAbandoned returns a channel closed once an abandoned call python is still running stops, or nil if there is
+// none. Each abandoned call is claimed once, so the worker slot of every abandoned call is held until it stops.
+func (p *process) claimAbandoned() <-chan struct{} {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	for _, a := range p.abandoned {
+		if !a.claimed {
+			a.claimed = true
+			return a.done
+		}
+	}
+	return nil
+}
+
+// settleAbandoned stops tracking the abandoned calls once the process dies.
+func (p *process) settleAbandoned() {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	for id, a := range p.abandoned {
+		delete(p.abandoned, id)
+		close(a.done)
+	}
+}
@@ -0,0 +1,44 @@
+package gopy
+
+import (
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Ragged is a list of 1-D arrays of different lengths, e.g. the rows of a ragged 2-D array, which numpy can't hold in
+// a single array. It is transported to and from python as a list of 1-D numpy arrays.
+type Ragged[T Element] [][]T
+
+func (r Ragged[T]) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeArrayLen(len(r)); err != nil {
+		return err
+	}
+	for _, row := range r {
+		if err := encode1D(enc, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Ragged[T]) DecodeMsgpack(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if n == -1 {
+		*r = nil
+		return nil
+	}
+	// the length comes from the payload, so rows are appended rather than allocated upfront
+	rows := make(Ragged[T], 0, min(n, 1024))
+	for i := range n {
+		row, err := decode1D[T](dec)
+		if err != nil {
+			return fmt.Errorf("decoding row %v of ragged array: %w", i, err)
+		}
+		rows = append(rows, row)
+	}
+	*r = rows
+	return nil
+}
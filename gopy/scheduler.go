@@ -0,0 +1,225 @@
+package gopy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned when load shedding is enabled and a call is rejected because queued calls are waiting
+// longer than the configured target latency.
+var ErrOverloaded = errors.New("python worker pool overloaded")
+
+// Priority orders queued calls waiting for a free worker. Higher priorities are served first, the zero value is
+// PriorityNormal.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+type priorityCtxKey struct{}
+
+// ContextWithPriority returns a copy of ctx carrying the given call priority. Calls made with the returned context are
+// queued with that priority unless overridden by the WithPriority call option.
+func ContextWithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityCtxKey{}, priority)
+}
+
+// PriorityFromContext returns the priority carried by ctx, or PriorityNormal if none was set.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityCtxKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+type waiter struct {
+	priority Priority
+	// accept restricts which workers the waiter can be served by, nil accepting any
+	accept   func(*PythonWrapper) bool
+	enqueued time.Time
+	ready    chan *PythonWrapper
+}
+
+// scheduler hands out idle workers to callers. When no worker is idle, callers are queued and served in order of
+// effective priority, which is their priority plus one level for every aging interval they have spent waiting, so
+// low priority work cannot be starved indefinitely by a steady stream of higher priority calls.
+//
+// If a shed target is set, calls that would have to queue are rejected with ErrOverloaded while the observed queue
+// wait exceeds the target for their priority.
+type scheduler struct {
+	mu      sync.Mutex
+	idle    []*PythonWrapper
+	waiters []*waiter
+	aging   time.Duration
+
+	shedTarget time.Duration
+	// waitEstimate is an exponentially weighted moving average of the time calls spent queued
+	waitEstimate time.Duration
+}
+
+// waitEstimateWeight is the weight given to each new observation in waitEstimate.
+const waitEstimateWeight = 0.2
+
+func newScheduler(aging, shedTarget time.Duration) *scheduler {
+	return &scheduler{aging: aging, shedTarget: shedTarget}
+}
+
+// acquire blocks until a worker is available for a call with the given priority or ctx is done.
+func (s *scheduler) acquire(ctx context.Context, priority Priority) (*PythonWrapper, error) {
+	return s.acquireMatching(ctx, priority, nil)
+}
+
+// acquireMatching is like acquire but only hands out workers accepted by accept, if set. Idle workers are only ever
+// idle while no queued call accepts them, so an accepted idle worker can be taken without jumping the queue.
+func (s *scheduler) acquireMatching(ctx context.Context, priority Priority, accept func(*PythonWrapper) bool) (*PythonWrapper, error) {
+	s.mu.Lock()
+	for i, w := range s.idle {
+		if accept == nil || accept(w) {
+			s.idle = append(s.idle[:i], s.idle[i+1:]...)
+			s.observeWait(0)
+			s.mu.Unlock()
+			return w, nil
+		}
+	}
+	now := time.Now()
+	if s.shouldShed(priority, now) {
+		s.mu.Unlock()
+		return nil, ErrOverloaded
+	}
+	wt := &waiter{priority: priority, accept: accept, enqueued: now, ready: make(chan *PythonWrapper, 1)}
+	s.waiters = append(s.waiters, wt)
+	s.mu.Unlock()
+
+	select {
+	case w := <-wt.ready:
+		return w, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.removeWaiter(wt)
+		s.mu.Unlock()
+		if !removed {
+			// a worker was handed over concurrently, pass it on
+			s.release(<-wt.ready)
+		}
+		return nil, context.Cause(ctx)
+	}
+}
+
+// tryAcquire returns an idle worker other than exclude without queueing. It never takes a worker ahead of queued
+// calls.
+func (s *scheduler) tryAcquire(exclude *PythonWrapper) (*PythonWrapper, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) > 0 {
+		return nil, false
+	}
+	for i, w := range s.idle {
+		if w != exclude {
+			s.idle = append(s.idle[:i], s.idle[i+1:]...)
+			return w, true
+		}
+	}
+	return nil, false
+}
+
+// release returns a worker to the scheduler, handing it straight to the most deserving waiter if there is one.
+func (s *scheduler) release(w *PythonWrapper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if wt := s.nextWaiter(now, w); wt != nil {
+		s.observeWait(now.Sub(wt.enqueued))
+		wt.ready <- w
+		return
+	}
+	s.idle = append(s.idle, w)
+	s.rematch(now)
+}
+
+// rematch hands idle workers to waiters that accept them now but didn't when they were queued, e.g. calls whose
+// affinity key moved off a worker that died.
+func (s *scheduler) rematch(now time.Time) {
+	for i := 0; i < len(s.idle) && len(s.waiters) > 0; {
+		wt := s.nextWaiter(now, s.idle[i])
+		if wt == nil {
+			i++
+			continue
+		}
+		s.observeWait(now.Sub(wt.enqueued))
+		wt.ready <- s.idle[i]
+		s.idle = append(s.idle[:i], s.idle[i+1:]...)
+	}
+}
+
+// add registers a new worker with the scheduler.
+func (s *scheduler) add(w *PythonWrapper) {
+	s.release(w)
+}
+
+// nextWaiter removes and returns the waiter accepting w with the highest effective priority, ties going to the longest
+// waiting.
+func (s *scheduler) nextWaiter(now time.Time, w *PythonWrapper) *waiter {
+	best := -1
+	var bestPriority time.Duration
+	for i, wt := range s.waiters {
+		if wt.accept != nil && !wt.accept(w) {
+			continue
+		}
+		p := s.effectivePriority(wt, now)
+		if best == -1 || p > bestPriority {
+			best, bestPriority = i, p
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	wt := s.waiters[best]
+	s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+	return wt
+}
+
+// effectivePriority is expressed as a duration so that aging raises priority continuously and ties between equal
+// levels are broken by waiting time.
+func (s *scheduler) effectivePriority(wt *waiter, now time.Time) time.Duration {
+	waited := now.Sub(wt.enqueued)
+	if s.aging <= 0 {
+		// no aging, priority strictly dominates and waiting time only breaks ties
+		return time.Duration(wt.priority)*(1<<50) + waited
+	}
+	return time.Duration(wt.priority)*s.aging + waited
+}
+
+func (s *scheduler) observeWait(d time.Duration) {
+	s.waitEstimate += time.Duration(waitEstimateWeight * float64(d-s.waitEstimate))
+}
+
+// shouldShed reports whether a call with the given priority that would have to queue should be rejected. The queue
+// wait is taken as the larger of the moving average and the wait of the oldest queued call, the latter reacting
+// immediately when workers stop freeing up. Each priority level above PriorityLow tolerates one more multiple of the
+// target, so low priority calls are shed first.
+func (s *scheduler) shouldShed(priority Priority, now time.Time) bool {
+	if s.shedTarget <= 0 {
+		return false
+	}
+	wait := s.waitEstimate
+	for _, wt := range s.waiters {
+		wait = max(wait, now.Sub(wt.enqueued))
+	}
+	levels := max(int(priority-PriorityLow), 0) + 1
+	return wait > time.Duration(levels)*s.shedTarget
+}
+
+func (s *scheduler) removeWaiter(wt *waiter) bool {
+	for i, other := range s.waiters {
+		if other == wt {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
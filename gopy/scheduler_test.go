@@ -0,0 +1,166 @@
+package gopy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newScheduler(0, 0)
+	w := &PythonWrapper{}
+	s.add(w)
+
+	held, err := s.acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	order := make(chan Priority, 3)
+	for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		go func() {
+			w, err := s.acquire(context.Background(), p)
+			if err != nil {
+				t.Errorf("acquire() error = %v", err)
+				return
+			}
+			order <- p
+			s.release(w)
+		}()
+		waitForWaiters(t, s, int(p)+2)
+	}
+	s.release(held)
+
+	for _, want := range []Priority{PriorityHigh, PriorityNormal, PriorityLow} {
+		if got := <-order; got != want {
+			t.Errorf("served priority %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSchedulerAging(t *testing.T) {
+	s := newScheduler(10*time.Millisecond, 0)
+	s.add(&PythonWrapper{})
+	held, _ := s.acquire(context.Background(), PriorityNormal)
+
+	order := make(chan Priority, 2)
+	go func() {
+		w, _ := s.acquire(context.Background(), PriorityLow)
+		order <- PriorityLow
+		s.release(w)
+	}()
+	waitForWaiters(t, s, 1)
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		w, _ := s.acquire(context.Background(), PriorityHigh)
+		order <- PriorityHigh
+		s.release(w)
+	}()
+	waitForWaiters(t, s, 2)
+	s.release(held)
+
+	if got := <-order; got != PriorityLow {
+		t.Errorf("served priority %v first, want aged low priority call", got)
+	}
+	<-order
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(0, 0)
+	w := &PythonWrapper{}
+	s.add(w)
+	held, _ := s.acquire(context.Background(), PriorityNormal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, PriorityNormal)
+		errs <- err
+	}()
+	waitForWaiters(t, s, 1)
+	cancel()
+	if err := <-errs; err == nil {
+		t.Fatalf("acquire() expected error after cancel")
+	}
+	s.release(held)
+	if got, err := s.acquire(context.Background(), PriorityNormal); err != nil || got != w {
+		t.Errorf("acquire() = %v, %v, want idle worker", got, err)
+	}
+}
+
+func waitForWaiters(t *testing.T, s *scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		got := len(s.waiters)
+		s.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v queued calls", n)
+}
+
+func TestSchedulerRematch(t *testing.T) {
+	s := newScheduler(0, 0)
+	a, b := &PythonWrapper{}, &PythonWrapper{}
+	s.add(a)
+	s.add(b)
+	held, _ := s.acquire(context.Background(), PriorityNormal)
+	other := b
+	if held == b {
+		other = a
+	}
+
+	// the waiter only comes to accept the idle worker once it was queued
+	var mu sync.Mutex
+	accepted := held
+	got := make(chan *PythonWrapper, 1)
+	go func() {
+		w, _ := s.acquireMatching(context.Background(), PriorityNormal, func(w *PythonWrapper) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return w == accepted
+		})
+		got <- w
+	}()
+	waitForWaiters(t, s, 1)
+	mu.Lock()
+	accepted = other
+	mu.Unlock()
+	s.release(held)
+	select {
+	case w := <-got:
+		if w != other {
+			t.Errorf("acquireMatching() = %p, want the idle worker %p", w, other)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter not handed the idle worker it now accepts")
+	}
+}
+
+func TestSchedulerLoadShedding(t *testing.T) {
+	s := newScheduler(0, 20*time.Millisecond)
+	s.add(&PythonWrapper{})
+	held, _ := s.acquire(context.Background(), PriorityNormal)
+
+	go func() {
+		_, _ = s.acquire(context.Background(), PriorityLow)
+	}()
+	waitForWaiters(t, s, 1)
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := s.acquire(context.Background(), PriorityLow); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("low priority acquire() error = %v, want ErrOverloaded", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, PriorityHigh); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("high priority acquire() error = %v, want to be queued until deadline", err)
+	}
+	s.release(held)
+}
@@ -0,0 +1,98 @@
+package gopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSessionClosed is returned when calling through a Session that has been closed.
+var ErrSessionClosed = errors.New("python worker session closed")
+
+// ErrSessionWorkerDied is returned by calls through a Session whose worker died. The worker's state is lost with it,
+// so the session is not moved to another worker.
+var ErrSessionWorkerDied = errors.New("python worker of session died")
+
+// Session reserves one worker of a pool exclusively for a sequence of calls, e.g. loading data into module level state
+// and then transforming and fitting it. No other calls run on the worker until the session is closed.
+type Session struct {
+	pool *Pool
+	proc *process
+	// slots holds every call slot of the worker, see WithWorkerConcurrency
+	slots  []*PythonWrapper
+	mu     sync.Mutex
+	closed bool
+}
+
+// Session waits for a worker and reserves it until the returned session is closed. The priority and affinity call
+// options apply to waiting for the worker.
+func (p *Pool) Session(ctx context.Context, opts ...CallOption) (*Session, error) {
+	cfg := newCallConfig(ctx, opts)
+	if p.cfg.concurrency > 1 {
+		// a session takes one call slot and then waits for the other slots of the same worker, reserving sessions one
+		// at a time so two sessions can't each hold some of the slots of one worker
+		p.sessionMu.Lock()
+		defer p.sessionMu.Unlock()
+	}
+	w, err := p.acquireCall(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for free python worker: %w", err)
+	}
+	s := &Session{pool: p, slots: []*PythonWrapper{w}}
+	if s.proc, err = w.process(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	for len(s.slots) < p.cfg.concurrency {
+		// once the worker dies any slot is accepted, its own slots being restarted or replaced, so the session fails
+		// instead of waiting for slots that will never be free
+		slot, err := p.acquireMatching(ctx, cfg.priority, func(other *PythonWrapper) bool {
+			return p.current(other) == w || s.proc.ctx.Err() != nil
+		})
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("waiting for python worker to become idle: %w", err)
+		}
+		s.slots = append(s.slots, slot)
+		if p.current(slot) != w || s.proc.ctx.Err() != nil {
+			s.Close()
+			return nil, fmt.Errorf("%w: %w", ErrSessionWorkerDied, context.Cause(s.proc.ctx))
+		}
+	}
+	return s, nil
+}
+
+// CallSession calls the python function on the session's worker.
+func CallSession[T any](ctx context.Context, s *Session, pythonFunctionName string, inputObj any, opts ...CallOption) (T, error) {
+	var result T
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return result, ErrSessionClosed
+	}
+	if s.proc.ctx.Err() != nil {
+		return result, fmt.Errorf("%w: %w", ErrSessionWorkerDied, context.Cause(s.proc.ctx))
+	}
+	cfg := newCallConfig(ctx, opts)
+	ctx = contextWithProgress(ctx, cfg.progress)
+	result, err := callProcess[T](ctx, s.proc, frameCall, pythonFunctionName, inputObj)
+	if err != nil && s.proc.ctx.Err() != nil {
+		return result, fmt.Errorf("%w: %w", ErrSessionWorkerDied, err)
+	}
+	return result, err
+}
+
+// Close ends the session, making its worker available to other calls again.
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, slot := range s.slots {
+		s.pool.release(slot)
+	}
+}
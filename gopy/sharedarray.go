@@ -0,0 +1,164 @@
+package gopy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrSharedArrayClosed is returned when updating a SharedArray that has been closed.
+var ErrSharedArrayClosed = errors.New("shared array closed")
+
+var sharedArrayName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SharedArray is a named array in shared memory that every worker of a pool can map read-only with gopyadapter's
+// shared_array(name), e.g. an embedding matrix used by many calls. The elements are written once rather than sent
+// with each call, and all workers map the same memory rather than holding a copy each.
+//
+// Update replaces the array by writing a new generation and swapping it in atomically: python sees either the old or
+// the new array, never a mix, and arrays already mapped by python stay valid until dropped.
+type SharedArray[T Element] struct {
+	pool       *Pool
+	name       string
+	mu         sync.Mutex
+	generation uint64
+	path       string
+	closed     bool
+}
+
+// sharedArrayRef is the content of the file naming the current generation of a shared array.
+type sharedArrayRef struct {
+	Generation uint64 `msgpack:"generation"`
+	Dtype      int    `msgpack:"dtype"`
+	Shape      []int  `msgpack:"shape"`
+	Path       string `msgpack:"path"`
+}
+
+// NewSharedArray places a copy of a in shared memory under name, which is made of letters, digits, '_' and '-' and
+// unique within the pool. The array lives until Close is called on it or the pool is closed.
+func NewSharedArray[T Element](p *Pool, name string, a NDArray[T]) (*SharedArray[T], error) {
+	if !sharedArrayName.MatchString(name) {
+		return nil, fmt.Errorf("invalid shared array name %q", name)
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("pool closed")
+	}
+	if p.sharedArrays[name] {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("shared array %q already exists", name)
+	}
+	p.sharedArrays[name] = true
+	p.mu.Unlock()
+	s := &SharedArray[T]{pool: p, name: name}
+	if err := s.Update(a); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SharedArray[T]) Name() string {
+	return s.name
+}
+
+// Generation returns the generation of the current array, starting at 1 and incremented by each Update.
+func (s *SharedArray[T]) Generation() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+// Update replaces the shared array with a copy of a, which may have a different shape. Calls already running keep the
+// array they mapped, later calls to shared_array in python return the new one.
+func (s *SharedArray[T]) Update(a NDArray[T]) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSharedArrayClosed
+	}
+	generation := s.generation + 1
+	path := fmt.Sprintf("%v.g%v", s.pool.sharedArrayPath(s.name), generation)
+	if err := writeSharedArray(path, a); err != nil {
+		return err
+	}
+	code, _ := dtypeOf[T]()
+	ref, err := msgpack.Marshal(sharedArrayRef{Generation: generation, Dtype: code, Shape: a.Shape, Path: path})
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	// renaming is atomic so python always reads a complete reference
+	refPath := s.pool.sharedArrayPath(s.name)
+	tmpPath := refPath + ".tmp"
+	if err := os.WriteFile(tmpPath, ref, 0o600); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("writing shared array reference: %w", err)
+	}
+	if err := os.Rename(tmpPath, refPath); err != nil {
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(path)
+		return fmt.Errorf("writing shared array reference: %w", err)
+	}
+	// workers that mapped the previous generation keep their mapping once it is removed
+	if s.path != "" {
+		removeSegments([]string{s.path})
+	}
+	s.generation = generation
+	s.path = path
+	return nil
+}
+
+// Close removes the shared array. Workers that mapped it keep their mapping, but shared_array(name) no longer finds it.
+func (s *SharedArray[T]) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	refPath := s.pool.sharedArrayPath(s.name)
+	removeSegments([]string{refPath})
+	if s.path != "" {
+		removeSegments([]string{s.path})
+	}
+	s.pool.mu.Lock()
+	delete(s.pool.sharedArrays, s.name)
+	s.pool.mu.Unlock()
+}
+
+func writeSharedArray[T Element](path string, a NDArray[T]) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating shared array: %w", err)
+	}
+	_, err = f.Write(a.AsOrder(OrderC).littleEndianBytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("writing shared array: %w", err)
+	}
+	return nil
+}
+
+// sharedArrayPath is the path of the file referring to the current generation of the pool's shared array name, the
+// generations themselves are written next to it.
+func (p *Pool) sharedArrayPath(name string) string {
+	return p.sharedArrayPrefix + name
+}
+
+// removeSharedArrays removes the shared arrays of the pool once it is closed.
+func (p *Pool) removeSharedArrays() {
+	paths, _ := filepath.Glob(p.sharedArrayPrefix + "*")
+	removeSegments(paths)
+}
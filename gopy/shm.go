@@ -0,0 +1,130 @@
+package gopy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// ndarrayShared flags an NDArray extension whose elements are in a shared memory segment. The payload holds the path of
+// the segment in place of the elements, and whoever decodes the array removes the segment.
+const ndarrayShared = 0x01
+
+// sharedMemoryDir is where shared memory segments are created, /dev/shm where available so they never touch a disk.
+var sharedMemoryDir = func() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}()
+
+var sharedMemoryIDs atomic.Uint64
+
+// sharedMemory passes the large arrays exchanged with one worker process through shared memory segments rather than
+// the pipe.
+type sharedMemory struct {
+	// arrays of at least this many bytes are shared
+	threshold int
+	// name prefixes of the segments created by go and by the worker for the process
+	prefix       string
+	workerPrefix string
+}
+
+func newSharedMemory(threshold, pid int) *sharedMemory {
+	return &sharedMemory{
+		threshold:    threshold,
+		prefix:       fmt.Sprintf("gopy-go-%v-%v-", os.Getpid(), sharedMemoryIDs.Add(1)),
+		workerPrefix: fmt.Sprintf("gopy-py-%v-", pid),
+	}
+}
+
+// create writes data to a new segment and returns its path.
+func (s *sharedMemory) create(data []byte) (string, error) {
+	path := filepath.Join(sharedMemoryDir, fmt.Sprintf("%v%v", s.prefix, sharedMemoryIDs.Add(1)))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("creating shared memory segment: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("writing shared memory segment: %w", err)
+	}
+	return path, nil
+}
+
+// removeAll removes the segments left over by the process, e.g. ones sent just before the worker died.
+func (s *sharedMemory) removeAll() {
+	for _, prefix := range []string{s.prefix, s.workerPrefix} {
+		paths, _ := filepath.Glob(filepath.Join(sharedMemoryDir, prefix+"*"))
+		removeSegments(paths)
+	}
+}
+
+// checkSegment checks path is a shared memory segment of size bytes.
+func checkSegment(path string, size int) error {
+	if filepath.Dir(path) != sharedMemoryDir || !strings.HasPrefix(filepath.Base(path), "gopy-") {
+		return fmt.Errorf("invalid shared memory segment %q", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("opening shared memory segment: %w", err)
+	}
+	if info.Size() != int64(size) {
+		return fmt.Errorf("shared memory segment of %v bytes holds %v bytes", size, info.Size())
+	}
+	return nil
+}
+
+// readSegment reads a shared memory segment into dest, which must be the size of the segment, and removes it.
+func readSegment(path string, dest []byte) error {
+	if filepath.Dir(path) != sharedMemoryDir || !strings.HasPrefix(filepath.Base(path), "gopy-") {
+		return fmt.Errorf("invalid shared memory segment %q", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening shared memory segment: %w", err)
+	}
+	defer f.Close()
+	// the open file stays readable once removed
+	_ = os.Remove(path)
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("reading shared memory segment: %w", err)
+	}
+	if info.Size() != int64(len(dest)) {
+		return fmt.Errorf("shared memory segment of %v bytes holds %v bytes", len(dest), info.Size())
+	}
+	if _, err := io.ReadFull(f, dest); err != nil {
+		return fmt.Errorf("reading shared memory segment: %w", err)
+	}
+	return nil
+}
+
+// removeSegments removes segments that weren't decoded, ignoring ones already removed.
+func removeSegments(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("removing shared memory segment: %v", err))
+		}
+	}
+}
+
+// sharedSegment returns the path of the segment an NDArray extension refers to, if any.
+func sharedSegment(extID int8, payload []byte) (string, bool) {
+	if int(extID) <= ExtNDArray || int(extID) > ExtNDArray+dtypeComplex128 || len(payload) < ndarrayHeaderLen {
+		return "", false
+	}
+	offset := ndarrayHeaderLen + 8*int(payload[0])
+	if payload[1]&ndarrayShared == 0 || len(payload) < offset {
+		return "", false
+	}
+	return string(payload[offset:]), true
+}
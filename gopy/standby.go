@@ -0,0 +1,158 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	standbyBackoffMin = 500 * time.Millisecond
+	standbyBackoffMax = 30 * time.Second
+)
+
+// acquire waits for a free worker, swapping in a standby if the worker handed out has died while idle.
+func (p *Pool) acquire(ctx context.Context, priority Priority) (*PythonWrapper, error) {
+	return p.acquireMatching(ctx, priority, nil)
+}
+
+// acquireMatching is like acquire but only hands out workers accepted by accept, if set. accept is passed the worker
+// as registered with the scheduler, which may since have been replaced, see current.
+func (p *Pool) acquireMatching(ctx context.Context, priority Priority, accept func(*PythonWrapper) bool) (*PythonWrapper, error) {
+	w, err := p.sched.acquireMatching(ctx, priority, accept)
+	if err != nil {
+		return nil, err
+	}
+	return p.replaceIfDead(w), nil
+}
+
+// current returns the worker that has taken w's place in the pool, or w itself if it has not been replaced.
+func (p *Pool) current(w *PythonWrapper) *PythonWrapper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for w.replacedBy != nil {
+		w = w.replacedBy
+	}
+	return w
+}
+
+// tryAcquire returns an idle worker other than exclude without queueing.
+func (p *Pool) tryAcquire(exclude *PythonWrapper) (*PythonWrapper, bool) {
+	w, ok := p.sched.tryAcquire(exclude)
+	if !ok {
+		return nil, false
+	}
+	return p.replaceIfDead(w), true
+}
+
+// release returns a worker to the scheduler, swapping in a standby if the worker died during the call. While python is
+// still running a call go abandoned on the worker, e.g. a cancelled synchronous function, the slot is only returned
+// once that call stops, so the next call doesn't queue behind it in python.
+func (p *Pool) release(w *PythonWrapper) {
+	if done := w.claimAbandoned(); done != nil {
+		go func() {
+			<-done
+			p.sched.release(p.replaceIfDead(w))
+		}()
+		return
+	}
+	p.sched.release(p.replaceIfDead(w))
+}
+
+// replaceIfDead returns w if its process is alive, otherwise a standby worker that takes its place in the pool. If no
+// standby is ready the dead worker is kept and restarted in place on its next call. A worker with several call slots
+// is handed to the scheduler once per slot, so once replaced, every slot of it maps to the same replacement. A worker
+// restarting in place counts as alive, so slots of it don't wait for the restart with the pool's lock held.
+func (p *Pool) replaceIfDead(w *PythonWrapper) *PythonWrapper {
+	defer p.replenishStandby()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for w.replacedBy != nil {
+		w = w.replacedBy
+	}
+	if w.alive() {
+		return w
+	}
+	sb := p.takeStandby()
+	if sb == nil {
+		return w
+	}
+	for i, other := range p.workers {
+		if other == w {
+			p.workers[i] = sb
+		}
+	}
+	w.replacedBy = sb
+	w.Close()
+	slog.InfoContext(p.ctx, "replaced dead python worker with standby")
+	return sb
+}
+
+// takeStandby removes and returns a live standby worker, or nil if none is ready. p.mu must be held.
+func (p *Pool) takeStandby() *PythonWrapper {
+	for len(p.standby) > 0 {
+		sb := p.standby[0]
+		p.standby = p.standby[1:]
+		if sb.alive() {
+			return sb
+		}
+		sb.Close()
+	}
+	return nil
+}
+
+// replenishStandby starts enough standby workers to bring the reserve back to the configured size. After a standby
+// fails to start, no new one is started until its backoff has passed, so a python that can't start isn't forked again
+// on every call.
+func (p *Pool) replenishStandby() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || time.Now().Before(p.standbyRetryAt) {
+		return
+	}
+	for ; len(p.standby)+p.starting < p.cfg.standby; p.starting++ {
+		go p.startStandby()
+	}
+}
+
+func (p *Pool) startStandby() {
+	w := p.newWorker()
+	_, err := w.InitProcess()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// catch up on broadcasts made while the standby was starting, which it may have missed
+	for err == nil && w.broadcastVer != p.broadcasts.current() {
+		p.mu.Unlock()
+		w.mu.Lock()
+		w.replayBroadcasts(w.proc)
+		w.mu.Unlock()
+		p.mu.Lock()
+	}
+	p.starting--
+	if err != nil {
+		delay := p.standbyBackoff()
+		slog.WarnContext(p.ctx, fmt.Sprintf("failed to start standby python worker, retrying in %v: %v", delay, err))
+		time.AfterFunc(delay, p.replenishStandby)
+		return
+	}
+	p.standbyFails = 0
+	if p.closed {
+		w.Close()
+		return
+	}
+	p.standby = append(p.standby, w)
+}
+
+// standbyBackoff records a failed standby start and returns how long to wait before starting another, doubling with
+// each consecutive failure. p.mu must be held.
+func (p *Pool) standbyBackoff() time.Duration {
+	delay := standbyBackoffMax
+	if p.standbyFails < 16 {
+		delay = min(standbyBackoffMin<<p.standbyFails, standbyBackoffMax)
+	}
+	p.standbyFails++
+	p.standbyRetryAt = time.Now().Add(delay)
+	return delay
+}
@@ -0,0 +1,93 @@
+package gopy
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// defaultStreamBuffer is the number of items a python generator may run ahead of the go consumer.
+const defaultStreamBuffer = 16
+
+func StreamDefault[T any](ctx context.Context, pythonFunctionName string, inputObj any, opts ...CallOption) iter.Seq2[T, error] {
+	return StreamPool[T](ctx, DefaultPool, pythonFunctionName, inputObj, opts...)
+}
+
+// StreamPool calls a python generator function (or async generator function) on a free worker and yields the items it
+// produces as they arrive. The generator only runs ahead of the consumer by a bounded number of items (see
+// WithStreamBuffer), stopping the iteration early closes the generator in python, and an exception raised mid-stream
+// is yielded as the final error. The worker is held for the duration of the iteration.
+func StreamPool[T any](ctx context.Context, p *Pool, pythonFunctionName string, inputObj any, opts ...CallOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		cfg := newCallConfig(ctx, opts)
+		ctx := contextWithProgress(ctx, cfg.progress)
+		worker, err := p.acquireCall(ctx, cfg)
+		if err != nil {
+			yield(zero, fmt.Errorf("waiting for free python worker: %w", err))
+			return
+		}
+		defer p.release(worker)
+		for item, err := range stream[T](ctx, worker, pythonFunctionName, inputObj, cfg.streamBuffer) {
+			if !yield(item, err) {
+				return
+			}
+		}
+	}
+}
+
+func stream[T any](ctx context.Context, w *PythonWrapper, pythonFunctionName string, inputObj any, buffer int) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		proc, err := w.process()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		// python may send up to buffer items plus the closing frame before waiting for credit, so the frames of the
+		// stream never block the frames of other calls on the worker
+		pc, err := proc.request(ctx, buffer+1, frameCall, pythonFunctionName, inputObj, map[string]any{"stream": buffer})
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer proc.finish(pc)
+
+		for {
+			f, err := proc.nextWithTimeout(ctx, pc)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			switch f.kind {
+			case frameItem:
+				var item T
+				if err := f.field(0, &item); err != nil {
+					proc.cancel(pc)
+					yield(zero, fmt.Errorf("unmarshalling stream item from child process: %v", err))
+					return
+				}
+				// replace the consumed item straight away so python keeps producing while the item is processed
+				if err := proc.write(frameCredit, pc.id, 1); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					proc.cancel(pc)
+					return
+				}
+			case frameEnd:
+				return
+			case frameError:
+				yield(zero, f.pythonError())
+				return
+			case frameResult:
+				yield(zero, fmt.Errorf("python function %v is not a generator", pythonFunctionName))
+				return
+			default:
+				yield(zero, fmt.Errorf("unexpected frame kind %v from child process", f.kind))
+				return
+			}
+		}
+	}
+}
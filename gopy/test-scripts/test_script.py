@@ -1,6 +1,12 @@
+import asyncio
+import os
+import threading
+import time
+
 import numpy as np
 
-from gopyadapter.core import execute
+from gopyadapter.core import GoError, call_go, call_go_async, execute, publish, remote, report_progress, \
+    file_array, shared_array
 
 
 def add(i):
@@ -52,5 +58,163 @@ def verify_1d_int32_array(i):
     return i
 
 
+def make_array(i):
+    return np.array(i['values'], dtype=i['dtype'])
+
+
+def make_text_array(i):
+    return np.array(i)
+
+
+def make_fortran_array(i):
+    return np.asfortranarray(np.array(i, dtype='float64'))
+
+
+def array_layout(i):
+    return {'fortran': bool(i.flags.f_contiguous and not i.flags.c_contiguous), 'values': i.tolist()}
+
+
+def ragged_lengths(i):
+    return [len(row) for row in i if isinstance(row, np.ndarray) and row.ndim == 1]
+
+
+def read_shared_array(i):
+    return shared_array(i)
+
+
+def scale_file_array(i):
+    a = i['input']
+    (a * i['factor']).tofile(i['output'])
+    return {'input': file_array(a), 'output': file_array(i['output'], a.dtype, a.shape), 'sum': float(a.sum())}
+
+
+def slow_once(i):
+    # the first call across all workers to claim the marker file is slow, any other call returns immediately
+    try:
+        fd = os.open(i['marker'], os.O_CREAT | os.O_EXCL)
+        os.close(fd)
+        time.sleep(5)
+        return 'slow'
+    except FileExistsError:
+        return 'fast'
+
+
+def sleep(i):
+    time.sleep(i['seconds'])
+    return i['seconds']
+
+
+async def async_sleep(i):
+    try:
+        await asyncio.sleep(i['seconds'])
+    except asyncio.CancelledError:
+        if 'marker' in i:
+            open(i['marker'], 'w').close()
+        raise
+    return i['seconds']
+
+
+def count(i):
+    try:
+        for n in range(i['n']):
+            if n == i.get('fail_at'):
+                raise ValueError(n)
+            yield n
+    finally:
+        if 'marker' in i:
+            open(i['marker'], 'w').close()
+
+
+async def async_count(i):
+    for n in range(i['n']):
+        await asyncio.sleep(0)
+        yield n
+
+
+def sum_stream(i):
+    return sum(i)
+
+
+async def async_sum_stream(i):
+    total = 0
+    async for n in i:
+        total += n
+    return total
+
+
+def lookup(i):
+    return [call_go('double', n) for n in i['values']]
+
+
+async def async_lookup(i):
+    return [await call_go_async('double', n) for n in i['values']]
+
+
+def lookup_error(i):
+    try:
+        call_go(i['handler'], 1)
+    except GoError as e:
+        return str(e)
+    return ''
+
+
+def fit(i):
+    for step in range(i['steps']):
+        report_progress((step + 1) / i['steps'], f'step {step + 1}', step=step + 1)
+    return 'done'
+
+
+def start_ticker(i):
+    def tick():
+        for n in range(i['n']):
+            publish(i['topic'], {'n': n})
+    threading.Thread(target=tick, daemon=True).start()
+    return True
+
+
+class Model:
+    def __init__(self, scale, marker):
+        self.scale = scale
+        self.marker = marker
+
+    def predict(self, x):
+        return [v * self.scale for v in x]
+
+    def pid(self):
+        return os.getpid()
+
+    def __del__(self):
+        if self.marker:
+            open(self.marker, 'w').close()
+
+
+def make_model(i):
+    return remote(Model(i['scale'], i.get('marker')))
+
+
+STATE = {}
+
+
+def set_state(i):
+    STATE.update(i)
+    return os.getpid()
+
+
+def get_state(i):
+    return STATE.get(i)
+
+
+def getpid(i):
+    return os.getpid()
+
+
+def fail(i):
+    raise ValueError(i)
+
+
+def crash(i):
+    os._exit(1)
+
+
 if __name__ == '__main__':
     execute(**globals())
\ No newline at end of file
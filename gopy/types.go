@@ -1,11 +1,12 @@
 package gopy
 
 import (
-	"encoding/binary"
+	"fmt"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
-// Extension types for different array types and dimensions
+// Extension types of the fixed rank array format used before NDArray. Arrays are now always sent in the NDArray
+// format (see ExtNDArray), gopyadapter still decodes these.
 const (
 	ExtFloat32    = 11
 	ExtFloat32_2D = 12
@@ -28,35 +29,13 @@ const (
 	ExtInt64_3D = 53
 )
 
-// Register all custom types with the MessagePack encoder/decoder
-func RegisterTypes() {
-	// Float32 types
-	//msgpack.RegisterExt(ExtFloat32, (*Float32_Array)(nil))
-	//msgpack.RegisterExt(ExtFloat32_2D, (*Float32_2DArray)(nil))
-	//msgpack.RegisterExt(ExtFloat32_3D, (*Float32_3DArray)(nil))
-	//
-	//// Float64 types
-	//msgpack.RegisterExt(ExtFloat64, (*Float64_Array)(nil))
-	//msgpack.RegisterExt(ExtFloat64_2D, (*Float64_2DArray)(nil))
-	//msgpack.RegisterExt(ExtFloat64_3D, (*Float64_3DArray)(nil))
-
-	// Int16 types
-	msgpack.RegisterExt(ExtInt16, (*Int16_Array)(nil))
-	msgpack.RegisterExt(ExtInt16_2D, (*Int16_2DArray)(nil))
-	msgpack.RegisterExt(ExtInt16_3D, (*Int16_3DArray)(nil))
-
-	// Int32 types
-	msgpack.RegisterExt(ExtInt32, (*Int32_Array)(nil))
-	msgpack.RegisterExt(ExtInt32_2D, (*Int32_2DArray)(nil))
-	msgpack.RegisterExt(ExtInt32_3D, (*Int32_3DArray)(nil))
-
-	// Int64 types
-	msgpack.RegisterExt(ExtInt64, (*Int64_Array)(nil))
-	msgpack.RegisterExt(ExtInt64_2D, (*Int64_2DArray)(nil))
-	msgpack.RegisterExt(ExtInt64_3D, (*Int64_3DArray)(nil))
-}
+// RegisterTypes is kept for compatibility, the array extensions are registered when the package is initialised.
+//
+// Deprecated: calling it is no longer needed.
+func RegisterTypes() {}
 
-// ------------------- Float32 types -------------------
+// The fixed rank array types are nested slices transported as numpy arrays of their element type and rank, encoded
+// through NDArray. Nested arrays must be rectangular, the shape is taken from the first element of each dimension.
 
 type Float32_Array []float32
 type Float32_2DArray [][]float32
@@ -78,419 +57,263 @@ type Int64_Array []int64
 type Int64_2DArray [][]int64
 type Int64_3DArray [][][]int64
 
-// ------------------- Float32 types -------------------
-
-func (arr Float32_Array) MarshalMsgpack() ([]byte, error) {
-	l := uint32(len(arr) * 4)
-	b := make([]byte, 6+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], l)
-	b[5] = byte(ExtFloat32)
-	float32ToBytes1D(arr, b[6:])
-	return b, nil
-}
-
-func (arr *Float32_Array) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	*arr = make(Float32_Array, len(data)/4)
-	bytesToFloat321D(data, *arr)
-	return nil
-}
-
-func (arr Float32_2DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-	}
-	l := d1 * d2 * 4
-	b := make([]byte, 6+8+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(8+l))
-	b[5] = byte(ExtFloat32_2D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	float32ToBytes2D(arr, b[14:])
-	return b, nil
-}
-
-func (arr *Float32_2DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
+// flatten2D returns the elements of a 2-D array, which must have rows of the same length.
+func flatten2D[T Element](arr [][]T) (NDArray[T], error) {
+	var cols int
+	if len(arr) > 0 {
+		cols = len(arr[0])
 	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	*arr = make(Float32_2DArray, d1)
-	data = data[8:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([]float32, d2)
-		bytesToFloat321D(data[i*d2*4:i*d2*4+d2*4], (*arr)[i])
+	data := make([]T, 0, len(arr)*cols)
+	for i, row := range arr {
+		if len(row) != cols {
+			return NDArray[T]{}, fmt.Errorf("ragged 2-d array: row %v has %v elements but row 0 has %v, "+
+				"use Ragged for rows of different lengths", i, len(row), cols)
+		}
+		data = append(data, row...)
 	}
-	return nil
+	return NDArray[T]{Data: data, Shape: []int{len(arr), cols}}, nil
 }
 
-func (arr Float32_3DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	var d3 int
-	if d1 > 0 {
+// flatten3D returns the elements of a 3-D array, which must have planes of the same shape.
+func flatten3D[T Element](arr [][][]T) (NDArray[T], error) {
+	var d2, d3 int
+	if len(arr) > 0 {
 		d2 = len(arr[0])
 		if d2 > 0 {
 			d3 = len(arr[0][0])
 		}
 	}
-	l := d1 * d2 * d3 * 4
-	b := make([]byte, 18+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(l+12))
-	b[5] = byte(ExtFloat32_3D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	binary.LittleEndian.PutUint32(b[14:18], uint32(d3))
-	float32ToBytes3D(arr, b[18:])
-	return b, nil
-}
-
-func (arr *Float32_3DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	d3 := int(binary.LittleEndian.Uint32(data[8:12]))
-	*arr = make(Float32_3DArray, d1)
-	data = data[12:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([][]float32, d2)
-		for j := 0; j < d2; j++ {
-			(*arr)[i][j] = make([]float32, d3)
-			bytesToFloat321D(data[i*d2*d3*4+j*d3*4:i*d2*d3*4+j*d3*4+d3*4], (*arr)[i][j])
+	data := make([]T, 0, len(arr)*d2*d3)
+	for i, plane := range arr {
+		if len(plane) != d2 {
+			return NDArray[T]{}, fmt.Errorf("ragged 3-d array: plane %v has %v rows but plane 0 has %v", i, len(plane), d2)
+		}
+		for j, row := range plane {
+			if len(row) != d3 {
+				return NDArray[T]{}, fmt.Errorf("ragged 3-d array: row [%v][%v] has %v elements but row [0][0] has %v",
+					i, j, len(row), d3)
+			}
+			data = append(data, row...)
 		}
 	}
-	return nil
+	return NDArray[T]{Data: data, Shape: []int{len(arr), d2, d3}}, nil
 }
 
-// ------------------- Float64 types -------------------
-
-func (arr Float64_Array) MarshalMsgpack() ([]byte, error) {
-	l := uint64(len(arr) * 8)
-	b := make([]byte, 6+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(l))
-	b[5] = byte(ExtFloat64)
-	float64ToBytes1D(arr, b[6:])
-	return b, nil
+// decodeArray decodes an array that must have ndim dimensions, or nil into the zero value.
+func decodeArray[T Element](dec *msgpack.Decoder, ndim int) (NDArray[T], error) {
+	var a NDArray[T]
+	if err := dec.Decode(&a); err != nil {
+		return a, err
+	}
+	if a.Shape == nil {
+		return a, nil
+	}
+	if a.Ndim() != ndim {
+		return a, fmt.Errorf("cannot decode %v-d array into a %v-d array type", a.Ndim(), ndim)
+	}
+	return a.AsOrder(OrderC), nil
 }
 
-func (arr *Float64_Array) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
+// nest2D returns the rows of a 2-D array, sharing its elements.
+func nest2D[T Element](a NDArray[T]) [][]T {
+	rows := make([][]T, a.Shape[0])
+	for i := range rows {
+		rows[i] = a.Index(i).Data
 	}
-	*arr = make(Float64_Array, len(data)/8)
-	bytesToFloat641D(data, *arr)
-	return nil
+	return rows
 }
 
-func (arr Float64_2DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	if d1 > 0 {
-		d2 = len(arr[0])
+// nest3D returns the planes of a 3-D array, sharing its elements.
+func nest3D[T Element](a NDArray[T]) [][][]T {
+	planes := make([][][]T, a.Shape[0])
+	for i := range planes {
+		planes[i] = nest2D(a.Index(i))
 	}
-	l := d1 * d2 * 8
-	b := make([]byte, 6+8+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(8+l))
-	b[5] = byte(ExtFloat64_2D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	float64ToBytes2D(arr, b[14:])
-	return b, nil
-}
-
-func (arr *Float64_2DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
+	return planes
+}
+
+func encode1D[T Element](enc *msgpack.Encoder, arr []T) error {
+	return enc.Encode(NDArray[T]{Data: arr, Shape: []int{len(arr)}})
+}
+
+func encode2D[T Element](enc *msgpack.Encoder, arr [][]T) error {
+	a, err := flatten2D(arr)
 	if err != nil {
 		return err
 	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	*arr = make(Float64_2DArray, d1)
-	data = data[8:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([]float64, d2)
-		bytesToFloat641D(data[i*d2*8:i*d2*8+d2*8], (*arr)[i])
-	}
-	return nil
+	return enc.Encode(a)
 }
 
-func (arr Float64_3DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	var d3 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-		if d2 > 0 {
-			d3 = len(arr[0][0])
-		}
-	}
-	l := d1 * d2 * d3 * 8
-	b := make([]byte, 18+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(l+12))
-	b[5] = byte(ExtFloat64_3D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	binary.LittleEndian.PutUint32(b[14:18], uint32(d3))
-	float64ToBytes3D(arr, b[18:])
-	return b, nil
-}
-
-func (arr *Float64_3DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
+func encode3D[T Element](enc *msgpack.Encoder, arr [][][]T) error {
+	a, err := flatten3D(arr)
 	if err != nil {
 		return err
 	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	d3 := int(binary.LittleEndian.Uint32(data[8:12]))
-	*arr = make(Float64_3DArray, d1)
-	data = data[12:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([][]float64, d2)
-		for j := 0; j < d2; j++ {
-			(*arr)[i][j] = make([]float64, d3)
-			bytesToFloat641D(data[i*d2*d3*8+j*d3*8:i*d2*d3*8+j*d3*8+d3*8], (*arr)[i][j])
-		}
+	return enc.Encode(a)
+}
+
+func decode1D[T Element](dec *msgpack.Decoder) ([]T, error) {
+	a, err := decodeArray[T](dec, 1)
+	return a.Data, err
+}
+
+func decode2D[T Element](dec *msgpack.Decoder) ([][]T, error) {
+	a, err := decodeArray[T](dec, 2)
+	if err != nil || a.Shape == nil {
+		return nil, err
+	}
+	return nest2D(a), nil
+}
+
+func decode3D[T Element](dec *msgpack.Decoder) ([][][]T, error) {
+	a, err := decodeArray[T](dec, 3)
+	if err != nil || a.Shape == nil {
+		return nil, err
 	}
-	return nil
+	return nest3D(a), nil
+}
+
+// ------------------- Float32 types -------------------
+
+func (arr Float32_Array) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode1D(enc, arr)
+}
+
+func (arr *Float32_Array) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode1D[float32](dec)
+	return err
+}
+
+func (arr Float32_2DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode2D(enc, arr)
+}
+
+func (arr *Float32_2DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode2D[float32](dec)
+	return err
+}
+
+func (arr Float32_3DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode3D(enc, arr)
+}
+
+func (arr *Float32_3DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode3D[float32](dec)
+	return err
+}
+
+// ------------------- Float64 types -------------------
+
+func (arr Float64_Array) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode1D(enc, arr)
+}
+
+func (arr *Float64_Array) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode1D[float64](dec)
+	return err
+}
+
+func (arr Float64_2DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode2D(enc, arr)
+}
+
+func (arr *Float64_2DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode2D[float64](dec)
+	return err
+}
+
+func (arr Float64_3DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode3D(enc, arr)
+}
+
+func (arr *Float64_3DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode3D[float64](dec)
+	return err
 }
 
 // ------------------- Int16 types -------------------
 
-func (arr Int16_Array) MarshalMsgpack() ([]byte, error) {
-	l := uint32(len(arr) * 2)
-	b := make([]byte, 6+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], l)
-	b[5] = byte(ExtInt16)
-	int16ToBytes1D(arr, b[6:])
-	return b, nil
+func (arr Int16_Array) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode1D(enc, arr)
 }
 
-func (arr *Int16_Array) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	*arr = make(Int16_Array, len(data)/2)
-	bytesToInt161D(data, *arr)
-	return nil
+func (arr *Int16_Array) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode1D[int16](dec)
+	return err
 }
 
-func (arr Int16_2DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-	}
-	l := d1 * d2 * 2
-	b := make([]byte, 6+8+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(8+l))
-	b[5] = byte(ExtInt16_2D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	int16ToBytes2D(arr, b[14:])
-	return b, nil
-}
-
-func (arr *Int16_2DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	*arr = make(Int16_2DArray, d1)
-	data = data[8:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([]int16, d2)
-		bytesToInt161D(data[i*d2*2:i*d2*2+d2*2], (*arr)[i])
-	}
-	return nil
+func (arr Int16_2DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode2D(enc, arr)
 }
 
-func (arr Int16_3DArray) MarshalMsgpack() ([]byte, error) {
-	panic("not implemented")
+func (arr *Int16_2DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode2D[int16](dec)
+	return err
 }
 
-func (arr *Int16_3DArray) UnmarshalMsgpack(data []byte) error {
-	panic("not implemented")
+func (arr Int16_3DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode3D(enc, arr)
+}
+
+func (arr *Int16_3DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode3D[int16](dec)
+	return err
 }
 
 // ------------------- Int32 types -------------------
 
-func (arr Int32_Array) MarshalMsgpack() ([]byte, error) {
-	l := uint32(len(arr) * 4)
-	b := make([]byte, 6+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], l)
-	b[5] = byte(ExtInt32)
-	int32ToBytes1D(arr, b[6:])
-	return b, nil
+func (arr Int32_Array) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode1D(enc, arr)
 }
 
-func (arr *Int32_Array) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	*arr = make(Int32_Array, len(data)/4)
-	bytesToInt321D(data, *arr)
-	return nil
+func (arr *Int32_Array) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode1D[int32](dec)
+	return err
 }
 
-func (arr Int32_2DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-	}
-	l := d1 * d2 * 4
-	b := make([]byte, 6+8+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(8+l))
-	b[5] = byte(ExtInt32_2D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	int32ToBytes2D(arr, b[14:])
-	return b, nil
-}
-
-func (arr *Int32_2DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	*arr = make(Int32_2DArray, d1)
-	data = data[8:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([]int32, d2)
-		bytesToInt321D(data[i*d2*4:i*d2*4+d2*4], (*arr)[i])
-	}
-	return nil
+func (arr Int32_2DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode2D(enc, arr)
 }
 
-func (arr Int32_3DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	var d3 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-		if d2 > 0 {
-			d3 = len(arr[0][0])
-		}
-	}
-	l := d1 * d2 * d3 * 4
-	b := make([]byte, 18+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(l+12))
-	b[5] = byte(ExtInt32_3D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	binary.LittleEndian.PutUint32(b[14:18], uint32(d3))
-	int32ToBytes3D(arr, b[18:])
-	return b, nil
-}
-
-func (arr *Int32_3DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	d3 := int(binary.LittleEndian.Uint32(data[8:12]))
-	*arr = make(Int32_3DArray, d1)
-	data = data[12:] // Adjust offset to skip the dimension headers
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([][]int32, d2)
-		for j := 0; j < d2; j++ {
-			(*arr)[i][j] = make([]int32, d3)
-			bytesToInt321D(data[i*d2*d3*4+j*d3*4:i*d2*d3*4+j*d3*4+d3*4], (*arr)[i][j])
-		}
-	}
-	return nil
+func (arr *Int32_2DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode2D[int32](dec)
+	return err
+}
+
+func (arr Int32_3DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode3D(enc, arr)
+}
+
+func (arr *Int32_3DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode3D[int32](dec)
+	return err
 }
 
 // ------------------- Int64 types -------------------
 
-func (arr Int64_Array) MarshalMsgpack() ([]byte, error) {
-	l := uint32(len(arr) * 8)
-	b := make([]byte, 6+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], l)
-	b[5] = byte(ExtInt64)
-	int64ToBytes1D(arr, b[6:])
-	return b, nil
+func (arr Int64_Array) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode1D(enc, arr)
 }
 
-func (arr *Int64_Array) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	*arr = make(Int64_Array, len(data)/8)
-	bytesToInt641D(data, *arr)
-	return nil
+func (arr *Int64_Array) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode1D[int64](dec)
+	return err
 }
 
-func (arr Int64_2DArray) MarshalMsgpack() ([]byte, error) {
-	var d1 = len(arr)
-	var d2 int
-	if d1 > 0 {
-		d2 = len(arr[0])
-	}
-	l := d1 * d2 * 8
-	b := make([]byte, 6+8+l)
-	b[0] = 0xC9
-	binary.BigEndian.PutUint32(b[1:5], uint32(8+l))
-	b[5] = byte(ExtInt64_2D)
-	binary.LittleEndian.PutUint32(b[6:10], uint32(d1))
-	binary.LittleEndian.PutUint32(b[10:14], uint32(d2))
-	int64ToBytes2D(arr, b[14:])
-	return b, nil
-}
-
-func (arr *Int64_2DArray) UnmarshalMsgpack(data []byte) error {
-	data, err := stripMsgPackHeader(data)
-	if err != nil {
-		return err
-	}
-	d1 := int(binary.LittleEndian.Uint32(data[:4]))
-	d2 := int(binary.LittleEndian.Uint32(data[4:8]))
-	*arr = make(Int64_2DArray, d1)
-	data = data[8:]
-	for i := 0; i < d1; i++ {
-		(*arr)[i] = make([]int64, d2)
-		bytesToInt641D(data[i*d2*8:i*d2*8+d2*8], (*arr)[i])
-	}
-	return nil
+func (arr Int64_2DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode2D(enc, arr)
+}
+
+func (arr *Int64_2DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode2D[int64](dec)
+	return err
 }
 
-func (arr Int64_3DArray) MarshalMsgpack() ([]byte, error) {
-	panic("not implemented")
+func (arr Int64_3DArray) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encode3D(enc, arr)
 }
 
-func (arr *Int64_3DArray) UnmarshalMsgpack(data []byte) error {
-	panic("not implemented")
+func (arr *Int64_3DArray) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	*arr, err = decode3D[int64](dec)
+	return err
 }
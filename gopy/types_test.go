@@ -0,0 +1,83 @@
+package gopy
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArrayTypes(t *testing.T) {
+	for _, in := range []any{
+		Int16_3DArray{{{1, 2}, {3, 4}}, {{5, 6}, {-7, 8}}},
+		Int64_3DArray{{{1, 2, 3}}, {{4, 5, 1 << 40}}},
+		Float32_Array{1.5, -2},
+		Float64_2DArray{{1, 2}, {3, 4}, {5, 6}},
+		Int32_3DArray{},
+	} {
+		data, err := msgpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal(%v) error = %v", in, err)
+		}
+		out := reflect.New(reflect.TypeOf(in))
+		if err := msgpack.Unmarshal(data, out.Interface()); err != nil {
+			t.Fatalf("Unmarshal(%v) error = %v", in, err)
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Errorf("round trip of %v = %v", in, out.Elem().Interface())
+		}
+	}
+
+	data, err := msgpack.Marshal(Int32_2DArray{{1, 2}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var wrongRank Int32_3DArray
+	if err := msgpack.Unmarshal(data, &wrongRank); err == nil {
+		t.Errorf("Unmarshal() of a 2-d array into Int32_3DArray error = nil, want error")
+	}
+
+	// arrays decoded into any are NDArrays of their element type
+	data, err = msgpack.Marshal(map[string]any{"a": Float32_2DArray{{1, 2}}, "b": NDArray[uint8]{Data: []uint8{7}, Shape: []int{}}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var m map[string]any
+	if err := msgpack.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := map[string]any{
+		"a": NDArray[float32]{Data: []float32{1, 2}, Shape: []int{1, 2}},
+		"b": NDArray[uint8]{Data: []uint8{7}, Shape: []int{}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Unmarshal() into map = %#v, want %#v", m, want)
+	}
+}
+
+func TestRaggedArrays(t *testing.T) {
+	for name, in := range map[string]any{
+		"2-d":       Float64_2DArray{{1, 2}, {3}},
+		"3-d plane": Int64_3DArray{{{1}}, {{2}, {3}}},
+		"3-d row":   Int16_3DArray{{{1, 2}, {3}}},
+	} {
+		if _, err := msgpack.Marshal(in); err == nil || !strings.Contains(err.Error(), "ragged") {
+			t.Errorf("Marshal() of ragged %v array error = %v, want ragged array error", name, err)
+		}
+	}
+
+	in := Ragged[float64]{{1, 2, 3}, {}, {4}}
+	data, err := msgpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var out Ragged[float64]
+	if err := msgpack.Unmarshal(data, &out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip of %v = %v, %v", in, out, err)
+	}
+	// rows are sent as 1-d arrays
+	var rows []any
+	if err := msgpack.Unmarshal(data, &rows); err != nil || !reflect.DeepEqual(rows[2], NDArray[float64]{Data: []float64{4}, Shape: []int{1}}) {
+		t.Errorf("Unmarshal() into []any = %#v, %v", rows, err)
+	}
+}
@@ -1,9 +1,21 @@
+import asyncio
+import collections
+import contextvars
+import inspect
+import functools
+import itertools
+import mmap
 import msgpack
 import os
+import sys
+import threading
+import traceback
+from concurrent.futures import CancelledError, ThreadPoolExecutor
+
 import numpy as np
 import struct
 
-# Extension codes for numpy array types and dimensions
+# Extension codes of the fixed rank array format used by older versions of gopy, still decoded
 EXT_FLOAT16 = 1
 EXT_FLOAT16_2D = 2
 EXT_FLOAT16_3D = 3
@@ -28,40 +40,6 @@ EXT_INT64 = 51
 EXT_INT64_2D = 52
 EXT_INT64_3D = 53
 
-# Mapping from numpy types to extension codes and byte formats
-NUMPY_TYPE_MAP = {
-    np.float16: {
-        1: (EXT_FLOAT16, '<f2'),
-        2: (EXT_FLOAT16_2D, '<f2'),
-        3: (EXT_FLOAT16_3D, '<f2')
-    },
-    np.float32: {
-        1: (EXT_FLOAT32, '<f4'),
-        2: (EXT_FLOAT32_2D, '<f4'),
-        3: (EXT_FLOAT32_3D, '<f4')
-    },
-    np.float64: {
-        1: (EXT_FLOAT64, '<f8'),
-        2: (EXT_FLOAT64_2D, '<f8'),
-        3: (EXT_FLOAT64_3D, '<f8')
-    },
-    np.int16: {
-        1: (EXT_INT16, '<i2'),
-        2: (EXT_INT16_2D, '<i2'),
-        3: (EXT_INT16_3D, '<i2')
-    },
-    np.int32: {
-        1: (EXT_INT32, '<i4'),
-        2: (EXT_INT32_2D, '<i4'),
-        3: (EXT_INT32_3D, '<i4')
-    },
-    np.int64: {
-        1: (EXT_INT64, '<i8'),
-        2: (EXT_INT64_2D, '<i8'),
-        3: (EXT_INT64_3D, '<i8')
-    }
-}
-
 # Reverse mapping from extension codes to numpy types and shapes
 EXT_TYPE_MAP = {
     EXT_FLOAT16: (np.dtype('<f2'), 1),
@@ -84,28 +62,231 @@ EXT_TYPE_MAP = {
     EXT_INT64_3D: (np.dtype('<i8'), 3)
 }
 
-# MessagePack extension packer for numpy arrays
-def default(obj):
+# Arrays of any number of dimensions use the extension type EXT_NDARRAY plus the code of their dtype, with a payload
+# of [ndim u8][flags u8][6 bytes padding], the shape as little endian uint64s and then the data in C order, see
+# gopy/ndarray.go
+EXT_NDARRAY = 64
+NDARRAY_HEADER = struct.Struct('<BB6x')
+
+NDARRAY_DTYPES = {
+    1: np.dtype('|b1'),
+    2: np.dtype('<i1'),
+    3: np.dtype('<u1'),
+    4: np.dtype('<i2'),
+    5: np.dtype('<u2'),
+    6: np.dtype('<i4'),
+    7: np.dtype('<u4'),
+    8: np.dtype('<i8'),
+    9: np.dtype('<u8'),
+    10: np.dtype('<f2'),
+    11: np.dtype('<f4'),
+    12: np.dtype('<f8'),
+    13: np.dtype('<c8'),
+    14: np.dtype('<c16'),
+}
+# Looked up by kind and size rather than type, numpy having several types for some dtypes, e.g. longlong and int64
+NDARRAY_CODES = {(dtype.kind, dtype.itemsize): code for code, dtype in NDARRAY_DTYPES.items()}
+
+
+def ndarray_code(dtype):
+    """Returns the code go knows the dtype by, or None if go doesn't support it."""
+    return NDARRAY_CODES.get((dtype.kind, dtype.itemsize))
+
+
+# Flags an array whose data is in a shared memory segment, the path of the segment taking the place of the data. The
+# side decoding the array removes the segment, see gopy/shm.go
+NDARRAY_SHARED = 0x01
+_segment_ids = itertools.count(1)
+# Flags an array stored in a file, the offset of the array (u64) and the path of the file taking the place of the data,
+# and whether it is mapped read-write, see gopy/filearray.go
+NDARRAY_FILE = 0x02
+NDARRAY_WRITABLE = 0x04
+# Flags an array whose data is in Fortran order
+NDARRAY_FORTRAN = 0x08
+
+
+def fortran_order(obj):
+    """Returns whether to send the array in Fortran order, which is only done if go asked for the order to be preserved.
+
+    Arrays not contiguous in either order are sent in the order closest to their layout.
+    """
+    if obj.ndim < 2 or obj.flags.c_contiguous or os.environ.get("GOPY_PRESERVE_ARRAY_ORDER") != "1":
+        return False
+    return obj.flags.f_contiguous or abs(obj.strides[0]) < abs(obj.strides[-1])
+
+
+def pack_ndarray(obj, share=False):
+    code = ndarray_code(obj.dtype)
+    obj = obj.astype(NDARRAY_DTYPES[code], copy=False)
+    flags = 0
+    order = 'C'
+    if fortran_order(obj):
+        flags |= NDARRAY_FORTRAN
+        order = 'F'
+    threshold = int(os.environ.get("GOPY_SHM_THRESHOLD", "0"))
+    if share and threshold and obj.nbytes >= threshold:
+        data = create_segment(obj, order).encode()
+        flags |= NDARRAY_SHARED
+    else:
+        data = obj.tobytes(order=order)
+    header = NDARRAY_HEADER.pack(obj.ndim, flags) + struct.pack(f'<{obj.ndim}Q', *obj.shape)
+    return msgpack.ExtType(EXT_NDARRAY + code, header + data)
+
+
+def create_segment(obj, order='C'):
+    """Writes the array to a new shared memory segment in the given order and returns its path."""
+    name = f"gopy-py-{os.getpid()}-{next(_segment_ids)}"
+    path = os.path.join(os.environ["GOPY_SHM_DIR"], name)
+    with open(path, "xb") as f:
+        # the transpose of an array in Fortran order is in C order, which is how tofile writes arrays
+        (obj.T if order == 'F' else obj).tofile(f)
+    return path
+
+
+def map_segment(path, dtype, shape, order='C'):
+    """Maps a shared memory segment created by go as a read-only array and removes it."""
+    fd = os.open(path, os.O_RDONLY)
+    try:
+        # the mapping outlives both the file name and the descriptor
+        os.unlink(path)
+        segment = mmap.mmap(fd, os.fstat(fd).st_size, access=mmap.ACCESS_READ)
+    finally:
+        os.close(fd)
+    return np.frombuffer(segment, dtype=dtype).reshape(shape, order=order)
+
+
+def map_file(path, dtype, shape, offset, writable):
+    """Opens an array stored in a file as a np.memmap."""
+    if not all(shape):
+        # empty arrays can't be mapped
+        return np.zeros(shape, dtype=dtype)
+    return np.memmap(path, dtype=dtype, mode='r+' if writable else 'r', offset=offset, shape=shape)
+
+
+class FileArray:
+    """Reference to an array stored in a file, returned to go in place of the array, see file_array."""
+
+    def __init__(self, path, dtype, shape, offset=0):
+        self.path = os.path.abspath(os.fspath(path))
+        self.dtype = np.dtype(dtype)
+        self.shape = tuple(int(d) for d in shape)
+        self.offset = int(offset)
+
+
+def file_array(array_or_path, dtype=None, shape=None, offset=0):
+    """Returns a reference to an array stored in a file, to return to go in place of the array itself.
+
+    Pass either a np.memmap of a whole array, which is flushed, or the path of the file with the dtype and shape of the
+    array and the offset of its first element in bytes. The array must be stored in C order with little endian elements.
+    """
+    if isinstance(array_or_path, np.memmap):
+        array = array_or_path
+        if not isinstance(array.base, mmap.mmap) or not array.flags.c_contiguous:
+            raise ValueError("file_array takes a memmap of a whole array in C order, not a view of one")
+        array.flush()
+        return FileArray(array.filename, array.dtype, array.shape, array.offset)
+    if dtype is None or shape is None:
+        raise TypeError("file_array takes the dtype and shape of the array stored at a path")
+    return FileArray(array_or_path, dtype, shape, offset)
+
+
+def pack_file_array(ref):
+    code = ndarray_code(ref.dtype)
+    if code is None or ref.dtype != NDARRAY_DTYPES[code]:
+        raise UnsupportedTypeError(f"cannot send file array of dtype {ref.dtype} to go")
+    header = NDARRAY_HEADER.pack(len(ref.shape), NDARRAY_FILE) + struct.pack(f'<{len(ref.shape)}QQ', *ref.shape, ref.offset)
+    return msgpack.ExtType(EXT_NDARRAY + code, header + ref.path.encode())
+
+
+def unpack_ndarray(code, data):
+    ndim, flags = NDARRAY_HEADER.unpack_from(data)
+    shape = struct.unpack_from(f'<{ndim}Q', data, NDARRAY_HEADER.size)
+    offset = NDARRAY_HEADER.size + 8 * ndim
+    dtype = NDARRAY_DTYPES[code - EXT_NDARRAY]
+    order = 'F' if flags & NDARRAY_FORTRAN else 'C'
+    if flags & NDARRAY_SHARED:
+        return map_segment(data[offset:].decode(), dtype, shape, order)
+    if flags & NDARRAY_FILE:
+        file_offset, = struct.unpack_from('<Q', data, offset)
+        return map_file(data[offset + 8:].decode(), dtype, shape, file_offset, flags & NDARRAY_WRITABLE)
+    return np.frombuffer(data, dtype=dtype, offset=offset).reshape(shape, order=order)
+
+
+# arrays placed in shared memory by go under a name, see gopy/sharedarray.go. Each maps to its generation and array
+_shared_arrays = {}
+_shared_arrays_lock = threading.Lock()
+
+
+def shared_array(name):
+    """Returns the current generation of the array shared by go under name, mapped read-only.
+
+    The array is mapped once per generation and shared by all callers in the worker. Once go updates it, the next call
+    returns the new generation while arrays already returned stay valid.
+    """
+    prefix = os.environ.get("GOPY_SHARED_ARRAYS")
+    if not prefix:
+        raise RuntimeError("shared_array can only be used in a gopy worker")
+    while True:
+        try:
+            with open(prefix + name, "rb") as f:
+                ref = msgpack.unpackb(f.read())
+        except FileNotFoundError:
+            raise LookupError(f"no shared array named {name!r}") from None
+        with _shared_arrays_lock:
+            cached = _shared_arrays.get(name)
+            if cached is not None and cached[0] == ref["generation"]:
+                return cached[1]
+        try:
+            array = map_shared_array(ref["path"], NDARRAY_DTYPES[ref["dtype"]], ref["shape"])
+        except FileNotFoundError:
+            # go swapped in a newer generation since the reference was read
+            continue
+        with _shared_arrays_lock:
+            cached = _shared_arrays.get(name)
+            if cached is None or cached[0] < ref["generation"]:
+                _shared_arrays[name] = (ref["generation"], array)
+        return array
+
+
+def map_shared_array(path, dtype, shape):
+    """Maps an array shared by go read-only, leaving the file for other workers."""
+    if not all(shape):
+        # empty files can't be mapped
+        return np.zeros(shape, dtype=dtype)
+    fd = os.open(path, os.O_RDONLY)
+    try:
+        segment = mmap.mmap(fd, os.fstat(fd).st_size, access=mmap.ACCESS_READ)
+    finally:
+        os.close(fd)
+    return np.frombuffer(segment, dtype=dtype).reshape(shape)
+
+
+class UnsupportedTypeError(TypeError):
+    """Raised when a value can't be sent to go."""
+
+
+# MessagePack extension packer for numpy arrays, share is whether large arrays may be sent in shared memory
+def default(obj, share=False):
+    if isinstance(obj, np.generic):
+        # numpy scalars, e.g. the result of arr.sum(), are sent as the equivalent python value
+        return obj.item()
     if isinstance(obj, np.ndarray):
-        ndim = obj.ndim
-        # Check if we support this data type and dimensionality
-        if obj.dtype.type in NUMPY_TYPE_MAP and ndim in NUMPY_TYPE_MAP[obj.dtype.type]:
-            ext_code, byte_format = NUMPY_TYPE_MAP[obj.dtype.type][ndim]
-            byte_data = obj.astype(byte_format).tobytes()
-
-            if ndim > 1:
-                # Instead of metadata object, use 4-byte integers for each dimension
-                shape_bytes = b''
-                for dim_size in obj.shape:
-                    shape_bytes += struct.pack('<I', dim_size)  # 4-byte little-endian unsigned int
-                packed_data = shape_bytes + byte_data
-            else:
-                packed_data = byte_data
+        if obj.dtype.type is np.float16 and os.environ.get("GOPY_FLOAT16_AS_FLOAT32") == "1":
+            obj = obj.astype('<f4')
+        if obj.dtype.kind == 'O':
+            raise UnsupportedTypeError(
+                "cannot send numpy array of dtype object to go, send ragged arrays as a list of 1-d arrays instead")
+        if ndarray_code(obj.dtype) is None:
+            supported = ", ".join(dtype.name for dtype in NDARRAY_DTYPES.values())
+            raise UnsupportedTypeError(
+                f"cannot send numpy array of dtype {obj.dtype} to go, supported dtypes are {supported}")
+        return pack_ndarray(obj, share)
+    if isinstance(obj, FileArray):
+        return pack_file_array(obj)
+    raise UnsupportedTypeError(f"cannot send object of type {obj.__class__.__name__} to go")
+
+_share_default = functools.partial(default, share=True)
 
-            return msgpack.ExtType(ext_code, packed_data)
-        else:
-            raise TypeError(f"Unsupported dtype: {obj.dtype} or dimension: {ndim}")
-    raise TypeError(f'Object of type {obj.__class__.__name__} is not MessagePack serializable')
 
 # MessagePack extension unpacker for numpy arrays
 def ext_hook(code, data):
@@ -119,27 +300,460 @@ def ext_hook(code, data):
         else:
             array = np.frombuffer(data, dtype=dtype)
         return array
+    if code - EXT_NDARRAY in NDARRAY_DTYPES:
+        return unpack_ndarray(code, data)
 
     return msgpack.ExtType(code, data)
 
-def execute(**kwargs):
-    rd, wd = 3, 4  # the read and write pipe indexes
-    with os.fdopen(rd, "rb") as rf:
-        os.write(wd, "ready".encode())
+# Frame kinds, every frame is a msgpack array [kind, request id, ...], see gopy/protocol.go
+FRAME_CALL = 1  # [kind, id, function name, input, (options)]
+FRAME_CANCEL = 2  # [kind, id]
+FRAME_CREDIT = 3  # [kind, id, number of further stream items that may be sent]
+FRAME_INPUT_CHUNK = 4  # [kind, id, [items of the input stream]]
+FRAME_INPUT_END = 5  # [kind, id]
+FRAME_CALLBACK_RESULT = 6  # [kind, id, callback id, result]
+FRAME_CALLBACK_ERROR = 7  # [kind, id, callback id, message]
+FRAME_METHOD = 8  # [kind, id, handle id, method name, input, (options)]
+FRAME_RELEASE = 9  # [kind, 0, handle id]
+FRAME_RESULT = 101  # [kind, id, result]
+FRAME_ERROR = 102  # [kind, id, exception type, message, traceback]
+FRAME_ITEM = 103  # [kind, id, item yielded by a generator]
+FRAME_END = 104  # [kind, id]
+FRAME_PULL = 105  # [kind, id], asks for the next chunk of the input stream
+FRAME_CALLBACK = 106  # [kind, id, callback id, handler name, payload], calls a go handler
+FRAME_PROGRESS = 107  # [kind, id, fraction, message, fields]
+FRAME_EVENT = 108  # [kind, 0, topic, payload], published independently of any call
+FRAME_CANCELLED = 109  # [kind, id], a cancelled call has stopped running
+
+
+class _Request:
+    def __init__(self, request_id, options):
+        self.id = request_id
+        self.cancelled = False
+        # set for calls running on the event loop so they can be cancelled
+        self.future = None
+        # number of items go is ready to receive if the call is streamed, None otherwise
+        self.stream = options.get("stream")
+        self.credits = self.stream or 0
+        # chunks of the input stream received from go, None marking its end
+        self.input_chunks = collections.deque()
+        # replies to call_go by callback id, (ok, result or error message)
+        self.callback_replies = {}
+        self.cond = threading.Condition()
+        # wake coroutines waiting on the request
+        self.wakers = set()
+
+    def update(self, fn):
+        """Applies fn to the request's state and wakes anything waiting on it."""
+        with self.cond:
+            fn()
+            self.cond.notify_all()
+            wakers = list(self.wakers)
+        for waker in wakers:
+            waker()
+
+    def wait(self, take):
+        """Blocks until take, called with the lock held, succeeds. Returns False if the call was cancelled instead."""
+        with self.cond:
+            while not self.cancelled and not take():
+                self.cond.wait()
+            return not self.cancelled
+
+    async def wait_async(self, take):
+        loop = asyncio.get_running_loop()
+        event = asyncio.Event()
+        waker = lambda: loop.call_soon_threadsafe(event.set)
+        try:
+            while True:
+                with self.cond:
+                    if self.cancelled:
+                        return False
+                    if take():
+                        return True
+                    event.clear()
+                    self.wakers.add(waker)
+                await event.wait()
+        finally:
+            with self.cond:
+                self.wakers.discard(waker)
+
+    def cancel(self):
+        def set_cancelled():
+            self.cancelled = True
+        self.update(set_cancelled)
+        if self.future is not None:
+            self.future.cancel()
+
+    def add_credits(self, n):
+        def add():
+            self.credits += n
+        self.update(add)
+
+    def add_input(self, chunk):
+        self.update(lambda: self.input_chunks.append(chunk))
+
+    def add_callback_reply(self, callback_id, ok, value):
+        self.update(lambda: self.callback_replies.__setitem__(callback_id, (ok, value)))
+
+    def _take_credit(self):
+        if self.credits == 0:
+            return False
+        self.credits -= 1
+        return True
+
+    def take_credit(self):
+        """Blocks until go is ready for another stream item. Returns False if the call was cancelled instead."""
+        return self.wait(self._take_credit)
+
+    async def take_credit_async(self):
+        return await self.wait_async(self._take_credit)
+
+
+# key identifying a result as a handle to a remote object
+HANDLE_KEY = "__gopy_handle__"
+
+
+class _Remote:
+    def __init__(self, obj):
+        self.obj = obj
+
+
+def remote(obj):
+    """Marks obj, returned from a function called by gopy, to be kept in the worker and returned to go as a handle.
+
+    Go can then call methods of obj through the handle until it is released.
+    """
+    return _Remote(obj)
+
+
+class GoError(Exception):
+    """Raised by call_go when the go handler returned an error."""
+
+
+# the worker and request of the call running in the current thread or task
+_current_call = contextvars.ContextVar("gopy_current_call", default=None)
+_callback_ids = itertools.count(1)
+# the worker serving gopy in this process, set by execute
+_worker = None
+
+
+def _start_callback(name, payload):
+    current = _current_call.get()
+    if current is None:
+        raise RuntimeError("call_go can only be used during a call from gopy")
+    worker, req = current
+    callback_id = next(_callback_ids)
+    worker.write_frame(FRAME_CALLBACK, req.id, callback_id, name, payload)
+    return req, callback_id
+
+
+def _callback_result(req, callback_id):
+    ok, value = req.callback_replies.pop(callback_id)
+    if not ok:
+        raise GoError(value)
+    return value
+
+
+def call_go(name, payload=None):
+    """Calls the go handler registered on the pool under name, blocking until its result is returned.
+
+    Raises GoError if the handler returned an error. Use call_go_async from coroutines.
+    """
+    req, callback_id = _start_callback(name, payload)
+    if not req.wait(lambda: callback_id in req.callback_replies):
+        raise CancelledError("call cancelled by gopy")
+    return _callback_result(req, callback_id)
+
+
+async def call_go_async(name, payload=None):
+    """Calls the go handler registered on the pool under name without blocking the event loop."""
+    req, callback_id = _start_callback(name, payload)
+    if not await req.wait_async(lambda: callback_id in req.callback_replies):
+        raise asyncio.CancelledError()
+    return _callback_result(req, callback_id)
+
+
+def report_progress(fraction, message="", **fields):
+    """Sends an interim progress report for the call running in the current thread or task to go.
+
+    fraction is the fraction of the work completed from 0 to 1, any keyword arguments are passed to go as fields. Go
+    considers a worker hung if it stays silent for too long, so long running calls should report progress regularly.
+    """
+    current = _current_call.get()
+    if current is None:
+        raise RuntimeError("report_progress can only be used during a call from gopy")
+    worker, req = current
+    worker.write_frame(FRAME_PROGRESS, req.id, float(fraction), message, fields)
+
+
+def publish(topic, payload=None):
+    """Publishes an event to go subscribers of topic.
+
+    Can be called at any time from any thread, e.g. a background thread, once the worker is running.
+    """
+    if _worker is None:
+        raise RuntimeError("publish can only be used once the gopy worker is running")
+    _worker.write_frame(FRAME_EVENT, 0, topic, payload)
+
+
+class _InputStream:
+    """Lazy iterator over an input stream passed by go, pulling chunks of items as they are consumed.
+
+    Supports both ``for`` and ``async for``.
+    """
+
+    def __init__(self, worker, req):
+        self.worker = worker
+        self.req = req
+        self.items = collections.deque()
+        self.ended = False
+
+    def _take_chunk(self):
+        if not self.req.input_chunks:
+            return False
+        chunk = self.req.input_chunks.popleft()
+        if chunk is None:
+            self.ended = True
+        else:
+            self.items.extend(chunk)
+        return True
+
+    def __iter__(self):
+        return self
+
+    def __next__(self):
+        while not self.items:
+            if self.ended:
+                raise StopIteration
+            self.worker.write_frame(FRAME_PULL, self.req.id)
+            if not self.req.wait(self._take_chunk):
+                raise CancelledError("call cancelled by gopy")
+        return self.items.popleft()
+
+    def __aiter__(self):
+        return self
+
+    async def __anext__(self):
+        while not self.items:
+            if self.ended:
+                raise StopAsyncIteration
+            self.worker.write_frame(FRAME_PULL, self.req.id)
+            if not await self.req.wait_async(self._take_chunk):
+                raise asyncio.CancelledError()
+        return self.items.popleft()
+
+
+class _Worker:
+    def __init__(self, functions, rf, wf):
+        self.functions = functions
+        self.rf = rf
+        self.wf = wf
+        self.write_lock = threading.Lock()
+        self.requests = {}
+        self.requests_lock = threading.Lock()
+        # gopy never has more than this many calls in flight on the worker. Synchronous functions run on a thread
+        # pool of this size (threads are only started as needed), coroutine functions all run on one event loop.
+        concurrency = int(os.environ.get("GOPY_WORKER_CONCURRENCY", "1"))
+        self.executor = ThreadPoolExecutor(max_workers=max(concurrency, 1))
+        self.loop = None
+        self.loop_lock = threading.Lock()
+        # objects go holds a handle to, by handle id
+        self.objects = {}
+        self.objects_lock = threading.Lock()
+        self.object_ids = itertools.count(1)
+
+    def event_loop(self):
+        with self.loop_lock:
+            if self.loop is None:
+                self.loop = asyncio.new_event_loop()
+                threading.Thread(target=self.loop.run_forever, name="gopy-asyncio", daemon=True).start()
+            return self.loop
+
+    def read_frame(self):
+        header = self.rf.read(4)
+        if len(header) < 4:
+            return None
+        data = self.rf.read(int.from_bytes(header, "big"))
+        return msgpack.unpackb(data, ext_hook=ext_hook, raw=False)
+
+    def write_frame(self, kind, request_id, *fields):
+        # Serialize before taking the lock so a result that can't be serialized fails without writing anything. Only
+        # results and stream items are shared, go removes their segments when the call finishes if it didn't use them
+        share = kind in (FRAME_RESULT, FRAME_ITEM)
+        data = msgpack.packb([kind, request_id, *fields], default=_share_default if share else default,
+                             use_bin_type=True)
+        with self.write_lock:
+            self.wf.write(len(data).to_bytes(4, "big"))
+            self.wf.write(data)
+            self.wf.flush()
+
+    def write_error(self, request_id, e):
+        tb = traceback.format_exc()
+        print(tb, file=sys.stderr, flush=True)
+        self.write_frame(FRAME_ERROR, request_id, type(e).__name__, str(e), tb)
+
+    def run(self):
         while True:
-            to_read = int.from_bytes(rf.read(4), "big")
-            func_name_bytes = rf.read(to_read)
-            func_name = func_name_bytes.decode()
+            frame = self.read_frame()
+            if frame is None:
+                # go closed the pipe
+                break
+            kind, request_id = frame[0], frame[1]
+            if kind in (FRAME_CALL, FRAME_METHOD):
+                self.start_call(frame)
+                continue
+            if kind == FRAME_RELEASE:
+                with self.objects_lock:
+                    self.objects.pop(frame[2], None)
+                continue
+            with self.requests_lock:
+                req = self.requests.get(request_id)
+            if req is None:
+                # the call already finished
+                continue
+            if kind == FRAME_CANCEL:
+                req.cancel()
+            elif kind == FRAME_CREDIT:
+                req.add_credits(frame[2])
+            elif kind == FRAME_INPUT_CHUNK:
+                req.add_input(frame[2])
+            elif kind == FRAME_INPUT_END:
+                req.add_input(None)
+            elif kind == FRAME_CALLBACK_RESULT:
+                req.add_callback_reply(frame[2], True, frame[3])
+            elif kind == FRAME_CALLBACK_ERROR:
+                req.add_callback_reply(frame[2], False, frame[3])
+        self.executor.shutdown(wait=False)
+
+    def start_call(self, frame):
+        kind, request_id = frame[0], frame[1]
+        # a method frame carries the handle id and method name in place of the function name
+        fields = frame[2:] if kind == FRAME_CALL else frame[3:]
+        func_input, options = fields[1], fields[2] if len(fields) > 2 else {}
+        try:
+            func = self.resolve(kind, frame)
+        except Exception as e:
+            self.write_error(request_id, e)
+            return
+        req = _Request(request_id, options)
+        with self.requests_lock:
+            self.requests[request_id] = req
+        if options.get("input_stream"):
+            func_input = _InputStream(self, req)
+        # functions always take their input, methods are called without arguments if go passed no input
+        args = (func_input,) if kind == FRAME_CALL or func_input is not None else ()
+        if inspect.iscoroutinefunction(func) or inspect.isasyncgenfunction(func):
+            req.future = asyncio.run_coroutine_threadsafe(self.handle_async_call(req, func, args), self.event_loop())
+            # rather than in handle_async_call, which doesn't run at all if cancelled before it starts
+            req.future.add_done_callback(lambda _: self.finish_call(req))
+        else:
+            self.executor.submit(self.handle_call, req, func, args)
+
+    def resolve(self, kind, frame):
+        if kind == FRAME_CALL:
+            func = self.functions.get(frame[2])
+            if func is None:
+                raise NameError(f"function {frame[2]!r} is not exposed to gopy")
+            return func
+        with self.objects_lock:
+            obj = self.objects.get(frame[2])
+        if obj is None:
+            raise LookupError(f"no remote object with handle {frame[2]}, it may have been released")
+        return getattr(obj, frame[3])
+
+    def encode_result(self, result):
+        if not isinstance(result, _Remote):
+            return result
+        with self.objects_lock:
+            handle_id = next(self.object_ids)
+            self.objects[handle_id] = result.obj
+        return {HANDLE_KEY: handle_id, "type": type(result.obj).__name__}
+
+    def handle_call(self, req, func, args):
+        # executor threads are reused, so the call is set and reset around each call
+        token = _current_call.set((self, req))
+        try:
+            if req.cancelled:
+                return
+            result = func(*args)
+            if inspect.isawaitable(result):
+                # e.g. a partial of a coroutine function, run it on the event loop and wait for it here
+                result = asyncio.run_coroutine_threadsafe(_await(result, (self, req)), self.event_loop()).result()
+            if inspect.isgenerator(result):
+                if req.stream is not None:
+                    self.stream_items(req, result)
+                    return
+                result = list(result)
+            self.write_frame(FRAME_RESULT, req.id, self.encode_result(result))
+        except Exception as e:
+            if not req.cancelled:
+                self.write_error(req.id, e)
+        finally:
+            _current_call.reset(token)
+            self.finish_call(req)
 
-            to_read = int.from_bytes(rf.read(4), "big")
-            func_input_data = rf.read(to_read)
-            func_input = msgpack.unpackb(func_input_data, ext_hook=ext_hook, raw=False)
+    async def handle_async_call(self, req, func, args):
+        # each call runs as its own task with a copy of the context
+        _current_call.set((self, req))
+        try:
+            if inspect.isasyncgenfunction(func):
+                agen = func(*args)
+                if req.stream is not None:
+                    await self.stream_async_items(req, agen)
+                    return
+                result = [item async for item in agen]
+            else:
+                result = await func(*args)
+            self.write_frame(FRAME_RESULT, req.id, self.encode_result(result))
+        except asyncio.CancelledError:
+            # cancelled by go, nobody is waiting for the result
+            pass
+        except Exception as e:
+            self.write_error(req.id, e)
+
+    def finish_call(self, req):
+        with self.requests_lock:
+            self.requests.pop(req.id, None)
+        if req.cancelled:
+            # go holds the worker slot of a cancelled call until it stops
+            self.write_frame(FRAME_CANCELLED, req.id)
+
+    def stream_items(self, req, gen):
+        try:
+            while req.take_credit():
+                try:
+                    item = next(gen)
+                except StopIteration:
+                    self.write_frame(FRAME_END, req.id)
+                    return
+                self.write_frame(FRAME_ITEM, req.id, item)
+        finally:
+            # runs the generator's cleanup if go stopped consuming early
+            gen.close()
+
+    async def stream_async_items(self, req, agen):
+        try:
+            while await req.take_credit_async():
+                try:
+                    item = await agen.__anext__()
+                except StopAsyncIteration:
+                    self.write_frame(FRAME_END, req.id)
+                    return
+                self.write_frame(FRAME_ITEM, req.id, item)
+        finally:
+            await agen.aclose()
 
-            result = kwargs[func_name](func_input)
 
-            # Serialize result with MessagePack
-            msg_to_write = msgpack.packb(result, default=default, use_bin_type=True)
+async def _await(awaitable, current_call):
+    _current_call.set(current_call)
+    return await awaitable
 
-            x = int.to_bytes(len(msg_to_write), 4, "big")
-            os.write(wd, x)
-            os.write(wd, msg_to_write)
\ No newline at end of file
+
+def execute(**kwargs):
+    global _worker
+    rd, wd = 3, 4  # the read and write pipe indexes
+    with os.fdopen(rd, "rb") as rf, os.fdopen(wd, "wb") as wf:
+        wf.write("ready".encode())
+        wf.flush()
+        _worker = _Worker(kwargs, rf, wf)
+        _worker.run()
@@ -0,0 +1,96 @@
+"""Fork server (zygote) for gopy workers.
+
+Started by gopy as ``python -m gopyadapter.forkserver <entry_script> [module ...]``. The listed modules are imported
+once, then every fork request received on the control socket (fd 3) forks a worker process that inherits the already
+imported modules and runs the entry script as ``__main__``.
+"""
+import fcntl
+import importlib
+import os
+import runpy
+import select
+import signal
+import socket
+import struct
+import sys
+import traceback
+
+CONTROL_FD = 3
+# fds of a forked worker: communication pipes (read, write), stdout, stderr
+WORKER_FDS = (3, 4, 1, 2)
+
+
+def main():
+    entry_script = sys.argv[1]
+    # mirror `python <entry_script>`, making modules next to the script importable (and preloadable)
+    sys.path.insert(0, os.path.dirname(os.path.abspath(entry_script)))
+    for name in sys.argv[2:]:
+        importlib.import_module(name)
+
+    sock = socket.socket(fileno=CONTROL_FD)
+    wake_r, wake_w = os.pipe()
+    os.set_blocking(wake_w, False)
+    signal.set_wakeup_fd(wake_w)
+    signal.signal(signal.SIGCHLD, lambda *_: None)
+
+    sock.send(b"R")
+    while True:
+        readable, _, _ = select.select([sock, wake_r], [], [])
+        if wake_r in readable:
+            os.read(wake_r, 4096)
+            _reap(sock)
+        if sock in readable:
+            msg, fds, _, _ = socket.recv_fds(sock, 16, len(WORKER_FDS))
+            if not msg:
+                # gopy closed the control socket
+                return
+            sys.stdout.flush()
+            sys.stderr.flush()
+            pid = os.fork()
+            if pid == 0:
+                _run_worker(entry_script, fds, [sock.detach(), wake_r, wake_w])
+            for fd in fds:
+                os.close(fd)
+            sock.send(b"P" + struct.pack("<i", pid))
+
+
+def _reap(sock):
+    while True:
+        try:
+            pid, status = os.waitpid(-1, os.WNOHANG)
+        except ChildProcessError:
+            return
+        if pid == 0:
+            return
+        sock.send(b"X" + struct.pack("<ii", pid, os.waitstatus_to_exitcode(status)))
+
+
+def _run_worker(entry_script, fds, server_fds):
+    code = 1
+    try:
+        signal.set_wakeup_fd(-1)
+        signal.signal(signal.SIGCHLD, signal.SIG_DFL)
+        for fd in server_fds:
+            os.close(fd)
+        # move the received fds out of the way first so installing them can't clobber one another
+        moved = [fcntl.fcntl(fd, fcntl.F_DUPFD, 10) for fd in fds]
+        for fd in fds:
+            os.close(fd)
+        for src, dst in zip(moved, WORKER_FDS):
+            os.dup2(src, dst)
+            os.close(src)
+        sys.argv = [entry_script]
+        runpy.run_path(entry_script, run_name="__main__")
+        code = 0
+    except SystemExit as e:
+        code = 0 if e.code is None else e.code if isinstance(e.code, int) else 1
+    except BaseException:
+        traceback.print_exc()
+    finally:
+        sys.stdout.flush()
+        sys.stderr.flush()
+        os._exit(code)
+
+
+if __name__ == "__main__":
+    main()